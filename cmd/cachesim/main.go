@@ -0,0 +1,163 @@
+// Command cachesim replays a key-access trace through `expiry.ExpiryMap` under different capacities, time-to-live
+// periods and eviction policies, and reports hit ratio, loader calls and peak size of each configuration.
+//
+// Usage:
+//
+//	cachesim [flags] [trace file]
+//
+// The trace is read from standard input if the file is omitted or is "-". See `readTrace` for its format.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "cachesim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("cachesim", flag.ContinueOnError)
+	capacities := flags.String("capacities", "1000", "comma-separated cache capacities, 0 for unlimited")
+	ttls := flags.String("ttls", "0", "comma-separated time-to-live periods, 0 for no expiry")
+	policies := flags.String("policies", policyFIFO, "comma-separated eviction policies - "+policyFIFO+", "+policyLRU)
+	interval := flags.Duration("interval", time.Millisecond, "time between accesses without timestamps")
+	format := flags.String("format", "table", "output format - table or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "csv" {
+		return fmt.Errorf("unknown output format %q", *format)
+	}
+
+	configs, err := parseConfigs(*policies, *capacities, *ttls)
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	trace, err := readTrace(in, *interval)
+	if err != nil {
+		return err
+	}
+
+	results := make([]result, 0, len(configs))
+	for _, cfg := range configs {
+		res, err := simulate(trace, cfg)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+
+	if *format == "csv" {
+		return writeCSV(stdout, results)
+	}
+	return writeTable(stdout, results)
+}
+
+// Returns all combinations of policies, capacities and time-to-live periods
+func parseConfigs(policies string, capacities string, ttls string) ([]config, error) {
+	caps := make([]int, 0)
+	for _, s := range strings.Split(capacities, ",") {
+		c, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || c < 0 {
+			return nil, fmt.Errorf("invalid capacity %q", s)
+		}
+		if c == 0 {
+			c = expiry.Unlimited
+		}
+		caps = append(caps, c)
+	}
+	periods := make([]time.Duration, 0)
+	for _, s := range strings.Split(ttls, ",") {
+		ttl, err := parseTTL(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, ttl)
+	}
+
+	configs := make([]config, 0)
+	for _, policy := range strings.Split(policies, ",") {
+		policy = strings.TrimSpace(policy)
+		if policy != policyFIFO && policy != policyLRU {
+			return nil, fmt.Errorf("unknown eviction policy %q", policy)
+		}
+		for _, c := range caps {
+			for _, ttl := range periods {
+				configs = append(configs, config{policy, c, ttl})
+			}
+		}
+	}
+	return configs, nil
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if s == "0" {
+		return expiry.Eternity, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid time-to-live %q", s)
+	}
+	if ttl == 0 {
+		return expiry.Eternity, nil
+	}
+	return ttl, nil
+}
+
+var header = []string{"policy", "capacity", "ttl", "requests", "hits", "hit ratio", "loads", "peak size"}
+
+func (r result) fields() []string {
+	capacity := "unlimited"
+	if r.capacity != expiry.Unlimited {
+		capacity = strconv.Itoa(r.capacity)
+	}
+	ttl := "none"
+	if r.ttl != expiry.Eternity {
+		ttl = r.ttl.String()
+	}
+	return []string{r.policy, capacity, ttl, strconv.Itoa(r.requests), strconv.Itoa(r.hits),
+		strconv.FormatFloat(r.hitRatio(), 'f', 4, 64), strconv.Itoa(r.loads), strconv.Itoa(r.peakSize)}
+}
+
+func writeTable(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, r := range results {
+		fmt.Fprintln(tw, strings.Join(r.fields(), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(header)
+	for _, r := range results {
+		_ = cw.Write(r.fields())
+	}
+	cw.Flush()
+	return cw.Error()
+}
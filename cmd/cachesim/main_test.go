@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCSV(t *testing.T) {
+	assertT := assert.New(t)
+
+	var out strings.Builder
+	err := run([]string{"-capacities", "1,0", "-ttls", "0", "-format", "csv"}, strings.NewReader("a\nb\na\n"), &out)
+	assertT.Nil(err)
+	assertT.Equal("policy,capacity,ttl,requests,hits,hit ratio,loads,peak size\n"+
+		"fifo,1,none,3,0,0.0000,3,1\n"+
+		"fifo,unlimited,none,3,1,0.3333,2,2\n", out.String())
+}
+
+func TestRunTable(t *testing.T) {
+	var out strings.Builder
+	err := run([]string{"-ttls", "1m", "-policies", "lru"}, strings.NewReader("a\na\n"), &out)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "1m0s")
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+}
+
+func TestRunInvalidFlags(t *testing.T) {
+	assertT := assert.New(t)
+
+	var out strings.Builder
+	assertT.ErrorContains(run([]string{"-capacities", "x"}, strings.NewReader(""), &out), "capacity")
+	assertT.ErrorContains(run([]string{"-ttls", "-1s"}, strings.NewReader(""), &out), "time-to-live")
+	assertT.ErrorContains(run([]string{"-policies", "lfu"}, strings.NewReader(""), &out), "policy")
+	assertT.ErrorContains(run([]string{"-format", "json"}, strings.NewReader(""), &out), "format")
+	assertT.NotNil(run([]string{"missing.trace"}, strings.NewReader(""), &out))
+}
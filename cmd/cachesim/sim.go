@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+)
+
+// Eviction policies
+const (
+	// the oldest inserted entry is evicted - native policy of `ExpiryMap`
+	policyFIFO = "fifo"
+	// the least recently used entry is evicted - hits re-insert the entry, hence time-to-live counts from the last access
+	policyLRU = "lru"
+)
+
+// Simulated cache configuration
+type config struct {
+	policy   string
+	capacity int           // `expiry.Unlimited` for unbounded cache
+	ttl      time.Duration // `expiry.Eternity` for no expiry
+}
+
+// Outcome of replaying a trace
+type result struct {
+	config
+	requests int
+	hits     int
+	loads    int
+	peakSize int
+}
+
+func (r result) hitRatio() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.hits) / float64(r.requests)
+}
+
+// Entry expiry scheduled in trace time
+type expiration struct {
+	key string
+	at  time.Time
+}
+
+// Tracks number of entries through map events
+type sizeTracker struct {
+	size int
+	peak int
+}
+
+func (st *sizeTracker) Listen(ev expiry.EventType, key string, val struct{}, err error) {
+	switch ev {
+	case expiry.Added:
+		st.size++
+		if st.size > st.peak {
+			st.peak = st.size
+		}
+	case expiry.Expired, expiry.Removed:
+		st.size--
+	}
+}
+
+// Replays the trace through a passive `ExpiryMap` without time-to-live. Expiry is simulated in trace time - before each access
+// the entries that have expired by then are removed from the map, so that they are neither hit nor counted in peak size.
+func simulate(trace []access, cfg config) (result, error) {
+	if cfg.policy != policyFIFO && cfg.policy != policyLRU {
+		return result{}, fmt.Errorf("unknown eviction policy %q", cfg.policy)
+	}
+	res := result{config: cfg}
+	sizes := &sizeTracker{}
+	em := expiry.NewPassiveExpiryMap[string, struct{}]().
+		WithMaxCapacity(cfg.capacity).
+		WithLoader(func(key string) (struct{}, error) {
+			res.loads++
+			return struct{}{}, nil
+		}).
+		AddListener(sizes)
+	defer em.Discard()
+
+	// entries are (re)inserted in trace order, hence expire in the same order
+	expires := make(map[string]time.Time)
+	queue := make([]expiration, 0)
+	inserted := func(key string, now time.Time) {
+		if cfg.ttl != expiry.Eternity {
+			expires[key] = now.Add(cfg.ttl)
+			queue = append(queue, expiration{key, expires[key]})
+		}
+	}
+
+	for _, acc := range trace {
+		for len(queue) > 0 && !acc.at.Before(queue[0].at) {
+			if exp := queue[0]; expires[exp.key].Equal(exp.at) {
+				em.Remove(exp.key)
+				delete(expires, exp.key)
+			}
+			queue = queue[1:]
+		}
+
+		res.requests++
+		if cfg.policy == policyLRU {
+			if _, ok := em.Peek(acc.key); ok {
+				res.hits++
+				em.Remove(acc.key)
+				_ = em.Put(acc.key, struct{}{})
+				inserted(acc.key, acc.at)
+				continue
+			}
+		}
+		loads := res.loads
+		if _, err := em.Get(acc.key); err != nil {
+			return res, err
+		}
+		if res.loads == loads {
+			res.hits++
+		} else {
+			inserted(acc.key, acc.at)
+		}
+	}
+	res.peakSize = sizes.peak
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+	"github.com/stretchr/testify/assert"
+)
+
+func keysTrace(keys ...string) []access {
+	trace := make([]access, len(keys))
+	for i, key := range keys {
+		trace[i] = access{time.Unix(int64(i), 0), key}
+	}
+	return trace
+}
+
+func TestSimulateFIFO(t *testing.T) {
+	assertT := assert.New(t)
+
+	res, err := simulate(keysTrace("a", "b", "a", "c", "b", "d", "a"), config{policyFIFO, 2, expiry.Eternity})
+	assertT.Nil(err)
+	assertT.Equal(7, res.requests)
+	assertT.Equal(2, res.hits)
+	assertT.Equal(5, res.loads)
+	assertT.Equal(2, res.peakSize)
+	assertT.InDelta(2.0/7, res.hitRatio(), 1e-9)
+}
+
+func TestSimulateLRU(t *testing.T) {
+	assertT := assert.New(t)
+
+	res, err := simulate(keysTrace("a", "b", "a", "c", "a", "b", "a"), config{policyLRU, 2, expiry.Eternity})
+	assertT.Nil(err)
+	assertT.Equal(3, res.hits) // "a" stays as the most recently used
+	assertT.Equal(4, res.loads)
+}
+
+func TestSimulateTTL(t *testing.T) {
+	assertT := assert.New(t)
+
+	trace := keysTrace("a", "b", "a", "c", "d", "e", "a")
+	res, err := simulate(trace, config{policyFIFO, expiry.Unlimited, 3 * time.Second})
+	assertT.Nil(err)
+	assertT.Equal(1, res.hits) // "a" expired before the last access
+	assertT.Equal(3, res.peakSize)
+
+	res, _ = simulate(trace, config{policyFIFO, expiry.Unlimited, expiry.Eternity})
+	assertT.Equal(2, res.hits)
+	assertT.Equal(5, res.peakSize)
+}
+
+func TestSimulateLRUWithTTL(t *testing.T) {
+	assertT := assert.New(t)
+
+	// hits of "a" every 2 seconds keep it alive with 3 seconds time-to-live
+	trace := keysTrace("a", "b", "a", "c", "a", "d", "a")
+	res, _ := simulate(trace, config{policyLRU, expiry.Unlimited, 3 * time.Second})
+	assertT.Equal(3, res.hits)
+	assertT.Equal(3, res.peakSize)
+
+	res, _ = simulate(trace, config{policyFIFO, expiry.Unlimited, 3 * time.Second})
+	assertT.Equal(2, res.hits) // "a" expires at 3s and again at 7s
+}
+
+func TestSimulateEvictedEntryExpiry(t *testing.T) {
+	assertT := assert.New(t)
+
+	// "a" is evicted at 2s and reloaded at 3s, hence it is still live at 5s
+	res, _ := simulate(keysTrace("a", "b", "c", "a", "b", "a"), config{policyFIFO, 2, 3 * time.Second})
+	assertT.Equal(1, res.hits)
+	assertT.Equal(2, res.peakSize)
+}
+
+func TestSimulateUnknownPolicy(t *testing.T) {
+	_, err := simulate(keysTrace("a"), config{"random", 2, expiry.Eternity})
+	assert.NotNil(t, err)
+}
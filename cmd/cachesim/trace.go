@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key access read from a trace
+type access struct {
+	at  time.Time
+	key string
+}
+
+// Reads trace of key accesses - one key per line, optionally preceded by a timestamp and whitespace.
+// Timestamp is either Unix time in seconds with optional fraction, or RFC 3339 time. Lines without timestamp
+// are placed `interval` after the previous access. Empty lines and lines starting with `#` are skipped.
+func readTrace(r io.Reader, interval time.Duration) ([]access, error) {
+	trace := make([]access, 0)
+	at := time.Unix(0, 0)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := line
+		if fields := strings.Fields(line); len(fields) == 2 {
+			if ts, ok := parseTimestamp(fields[0]); ok {
+				if len(trace) > 0 && ts.Before(at) {
+					return nil, fmt.Errorf("line %d: timestamp goes back in time", lineNo)
+				}
+				at, key = ts, fields[1]
+			} else if len(trace) > 0 {
+				at = at.Add(interval)
+			}
+		} else if len(trace) > 0 {
+			at = at.Add(interval)
+		}
+		trace = append(trace, access{at, key})
+	}
+	return trace, scanner.Err()
+}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(secs, 0) && !math.IsNaN(secs) {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*float64(time.Second))), true
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTrace(t *testing.T) {
+	assertT := assert.New(t)
+
+	trace, err := readTrace(strings.NewReader("# comment\na\n\nb c\n100.5 d\ne\n2024-01-01T00:00:00Z f\n"), time.Second)
+	assertT.Nil(err)
+	keys := make([]string, 0)
+	times := make([]time.Time, 0)
+	for _, acc := range trace {
+		keys = append(keys, acc.key)
+		times = append(times, acc.at)
+	}
+	assertT.Equal([]string{"a", "b c", "d", "e", "f"}, keys)
+	assertT.True(times[0].Equal(time.Unix(0, 0)))
+	assertT.True(times[1].Equal(time.Unix(1, 0)))
+	assertT.True(times[2].Equal(time.Unix(100, 5e8)))
+	assertT.True(times[3].Equal(time.Unix(101, 5e8)))
+	assertT.True(times[4].Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestReadTraceBackInTime(t *testing.T) {
+	_, err := readTrace(strings.NewReader("200 a\n100 b\n"), time.Second)
+	assert.ErrorContains(t, err, "line 2")
+}
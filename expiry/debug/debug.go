@@ -0,0 +1,323 @@
+// Package "debug" provides HTTP handler and `expvar` publishing for inspecting ExpiryMap contents at runtime.
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+)
+
+// Default number of keys on a page
+const DefaultPageSize = 100
+
+// Largest number of keys on a page, larger `size` parameters are reduced to it
+const MaxPageSize = 10000
+
+// Name of the header or form field that carries the token for actions
+const TokenField = "X-Debug-Token"
+
+// Cache as seen by the handler. Implemented by `ExpiryMap`, `ShardedExpiryMap` and `Tiered`.
+type Source[K comparable, V any] interface {
+	Capacity() int
+	ExpireTime() time.Duration
+	Len() int
+	Stats() expiry.Stats
+	Keys() []K
+	RemainingTTL(key K) (time.Duration, bool)
+	Remove(key K) bool
+	Clear()
+}
+
+// Configuration and state of the cache, shared by the handler and `expvar`
+type Summary struct {
+	Capacity   int          `json:"capacity"`
+	ExpireTime string       `json:"expireTime"`
+	Len        int          `json:"len"`
+	HitRatio   float64      `json:"hitRatio"`
+	Stats      expiry.Stats `json:"stats"`
+}
+
+// Key with its remaining time-to-live
+type KeyInfo struct {
+	Key          string `json:"key"`
+	RemainingTTL string `json:"remainingTTL"`
+}
+
+// Page of the cache view
+type Page struct {
+	Summary
+	Page       int       `json:"page"`
+	PageSize   int       `json:"pageSize"`
+	TotalKeys  int       `json:"totalKeys"`
+	Keys       []KeyInfo `json:"keys"`
+	PrevPage   int       `json:"prevPage,omitempty"`
+	NextPage   int       `json:"nextPage,omitempty"`
+	HasActions bool      `json:"-"`
+}
+
+// HTTP handler that renders the cache as JSON or HTML.
+//
+// GET request returns the view; `page` (from 1) and `size` query parameters select a page of keys. The format is taken from `format`
+// query parameter ("json" or "html"), otherwise HTML is returned to clients that accept it and JSON to the others.
+// POST request performs an action given by `action` form field - "invalidate" removes the key from `key` field, "clear" clears the cache.
+// Actions are rejected unless enabled with `WithActions`.
+type Handler[K comparable, V any] struct {
+	cache     Source[K, V]
+	pageSize  int
+	token     string
+	parseKey  func(s string) (K, error)
+	formatKey func(key K) string
+}
+
+// Creates handler for the cache with default page size and disabled actions
+func NewHandler[K comparable, V any](cache Source[K, V]) *Handler[K, V] {
+	return &Handler[K, V]{
+		cache:     cache,
+		pageSize:  DefaultPageSize,
+		formatKey: func(key K) string { return fmt.Sprint(key) },
+	}
+}
+
+// Modifies default number of keys on a page
+func (h *Handler[K, V]) WithPageSize(size int) *Handler[K, V] {
+	h.pageSize = size
+	return h
+}
+
+// Enables POST actions for requests that carry the token in `X-Debug-Token` header or form field. Empty token disables actions.
+func (h *Handler[K, V]) WithActions(token string) *Handler[K, V] {
+	h.token = token
+	return h
+}
+
+// Modifies conversion of keys to strings in the view. By default keys are formatted with `fmt.Sprint`.
+func (h *Handler[K, V]) WithKeyFormatter(format func(key K) string) *Handler[K, V] {
+	h.formatKey = format
+	return h
+}
+
+// Sets conversion of `key` form field to a key for "invalidate" action.
+// By default the field is matched against formatted keys present in the cache.
+func (h *Handler[K, V]) WithKeyParser(parse func(s string) (K, error)) *Handler[K, V] {
+	h.parseKey = parse
+	return h
+}
+
+// Serves the cache view and actions
+func (h *Handler[K, V]) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveView(w, req)
+	case http.MethodPost:
+		h.serveAction(w, req)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler[K, V]) serveView(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	pageNum, err := intParam(query.Get("page"), 1, math.MaxInt)
+	if err != nil {
+		http.Error(w, "invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	size, err := intParam(query.Get("size"), h.pageSize, MaxPageSize)
+	if err != nil {
+		http.Error(w, "invalid size: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := h.page(pageNum, size)
+	if wantsHTML(req) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = pageTemplate.Execute(w, page)
+	} else {
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+func (h *Handler[K, V]) page(pageNum int, size int) Page {
+	keys := h.cache.Keys()
+	ret := Page{
+		Summary:    Summarize[K, V](h.cache),
+		Page:       pageNum,
+		PageSize:   size,
+		TotalKeys:  len(keys),
+		Keys:       []KeyInfo{},
+		HasActions: h.token != "",
+	}
+
+	// dividing rather than multiplying keeps large numbers from overflowing
+	if len(keys) == 0 || pageNum-1 > (len(keys)-1)/size {
+		return ret
+	}
+	from := (pageNum - 1) * size
+	if pageNum > 1 {
+		ret.PrevPage = pageNum - 1
+	}
+	to := len(keys)
+	if size < to-from {
+		to = from + size
+		ret.NextPage = pageNum + 1
+	}
+	for _, key := range keys[from:to] {
+		if ttl, ok := h.cache.RemainingTTL(key); ok {
+			ret.Keys = append(ret.Keys, KeyInfo{Key: h.formatKey(key), RemainingTTL: formatTTL(ttl)})
+		}
+	}
+	return ret
+}
+
+func (h *Handler[K, V]) serveAction(w http.ResponseWriter, req *http.Request) {
+	if h.token == "" {
+		http.Error(w, "actions are disabled", http.StatusForbidden)
+		return
+	}
+	token := req.Header.Get(TokenField)
+	if token == "" {
+		token = req.FormValue(TokenField)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+
+	var result map[string]any
+	switch action := req.FormValue("action"); action {
+	case "invalidate":
+		key, found, err := h.lookupKey(req.FormValue("key"))
+		if err != nil {
+			http.Error(w, "invalid key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = map[string]any{"action": action, "removed": found && h.cache.Remove(key)}
+	case "clear":
+		size := h.cache.Len()
+		h.cache.Clear()
+		result = map[string]any{"action": action, "removed": size}
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+
+	if wantsHTML(req) {
+		http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+	} else {
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// Converts form field to a key; the second value is `false` if the key is definitely not in the cache
+func (h *Handler[K, V]) lookupKey(s string) (K, bool, error) {
+	if h.parseKey != nil {
+		key, err := h.parseKey(s)
+		return key, err == nil, err
+	}
+	for _, key := range h.cache.Keys() {
+		if h.formatKey(key) == s {
+			return key, true, nil
+		}
+	}
+	var zero K
+	return zero, false, nil
+}
+
+// Returns configuration and state of the cache
+func Summarize[K comparable, V any](cache Source[K, V]) Summary {
+	stats := cache.Stats()
+	return Summary{
+		Capacity:   cache.Capacity(),
+		ExpireTime: formatTTL(cache.ExpireTime()),
+		Len:        cache.Len(),
+		HitRatio:   stats.HitRatio(),
+		Stats:      stats,
+	}
+}
+
+// Publishes summary of the cache in `expvar` under the name. Like `expvar.Publish`, panics if the name is already in use.
+func Publish[K comparable, V any](name string, cache Source[K, V]) {
+	expvar.Publish(name, expvar.Func(func() any { return Summarize[K, V](cache) }))
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl == expiry.Eternity {
+		return "never"
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+// Parses positive integer parameter, reducing it to `max`
+func intParam(s string, deflt int, max int) (int, error) {
+	if s == "" {
+		return deflt, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err == nil && n < 1 {
+		err = fmt.Errorf("%d is not positive", n)
+	}
+	if n > max {
+		n = max
+	}
+	return n, err
+}
+
+func wantsHTML(req *http.Request) bool {
+	switch req.URL.Query().Get("format") {
+	case "html":
+		return true
+	case "json":
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>ExpiryMap</title></head>
+<body>
+<h1>ExpiryMap</h1>
+<table>
+<tr><th align="left">Capacity</th><td>{{.Capacity}}</td></tr>
+<tr><th align="left">Expire time</th><td>{{.ExpireTime}}</td></tr>
+<tr><th align="left">Entries</th><td>{{.Len}}</td></tr>
+<tr><th align="left">Hit ratio</th><td>{{printf "%.3f" .HitRatio}}</td></tr>
+<tr><th align="left">Hits / misses</th><td>{{.Stats.Hits}} / {{.Stats.Misses}}</td></tr>
+<tr><th align="left">Loads / failures</th><td>{{.Stats.Loads}} / {{.Stats.LoadFailures}}</td></tr>
+<tr><th align="left">Expirations / evictions / removals</th><td>{{.Stats.Expirations}} / {{.Stats.Evictions}} / {{.Stats.Removals}}</td></tr>
+</table>
+<h2>Keys {{len .Keys}} of {{.TotalKeys}}, page {{.Page}}</h2>
+<table>
+<tr><th align="left">Key</th><th align="left">Remaining TTL</th>{{if .HasActions}}<th></th>{{end}}</tr>
+{{- $actions := .HasActions}}
+{{range .Keys}}<tr><td>{{.Key}}</td><td>{{.RemainingTTL}}</td>
+{{- if $actions}}<td><form method="post"><input type="hidden" name="action" value="invalidate"><input type="hidden" name="key" value="{{.Key}}">` +
+	`<input type="password" name="X-Debug-Token" placeholder="token"><button>Invalidate</button></form></td>{{end}}</tr>
+{{end}}</table>
+<p><a href="?format=html&page={{.Page}}&size={{.PageSize}}">Refresh</a>
+{{- if .PrevPage}} <a href="?format=html&page={{.PrevPage}}&size={{.PageSize}}">Previous</a>{{end}}
+{{- if .NextPage}} <a href="?format=html&page={{.NextPage}}&size={{.PageSize}}">Next</a>{{end}}</p>
+{{- if .HasActions}}
+<form method="post"><input type="hidden" name="action" value="clear"><input type="password" name="X-Debug-Token" placeholder="token"><button>Clear cache</button></form>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,226 @@
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+	"github.com/stretchr/testify/assert"
+)
+
+const token = "secret"
+
+func newMap() *expiry.ExpiryMap[string, int] {
+	em := expiry.NewExpiryMap[string, int]().
+		WithMaxCapacity(10).
+		ExpireAfter(time.Hour)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_ = em.Put(key, len(key))
+	}
+	return em
+}
+
+func getPage(t *testing.T, srv *httptest.Server, query string) Page {
+	resp, err := srv.Client().Get(srv.URL + "?" + query)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var page Page
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&page))
+	return page
+}
+
+func post(t *testing.T, srv *httptest.Server, form url.Values, tkn string) (int, map[string]any) {
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if tkn != "" {
+		req.Header.Set(TokenField, tkn)
+	}
+	resp, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	var result map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestJSONView(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := newMap()
+	defer em.Discard()
+	_, _ = em.Get("a")
+	srv := httptest.NewServer(NewHandler[string, int](em).WithPageSize(2))
+	defer srv.Close()
+
+	page := getPage(t, srv, "")
+	assertT.Equal(10, page.Capacity)
+	assertT.Equal("1h0m0s", page.ExpireTime)
+	assertT.Equal(5, page.Len)
+	assertT.Equal(uint64(1), page.Stats.Hits)
+	assertT.Equal(1.0, page.HitRatio)
+	assertT.Equal(5, page.TotalKeys)
+	assertT.Equal(1, page.Page)
+	assertT.Equal(2, page.PageSize)
+	assertT.Equal(0, page.PrevPage)
+	assertT.Equal(2, page.NextPage)
+	assertT.Len(page.Keys, 2)
+	assertT.Equal("a", page.Keys[0].Key)
+	ttl, err := time.ParseDuration(page.Keys[0].RemainingTTL)
+	assertT.Nil(err)
+	assertT.Greater(ttl, 59*time.Minute)
+
+	page = getPage(t, srv, "page=3")
+	assertT.Equal([]KeyInfo{{"e", page.Keys[0].RemainingTTL}}, page.Keys)
+	assertT.Equal(2, page.PrevPage)
+	assertT.Equal(0, page.NextPage)
+
+	page = getPage(t, srv, "page=2&size=10")
+	assertT.Empty(page.Keys)
+
+	page = getPage(t, srv, "page=1&size=4611686018427387904")
+	assertT.Equal(MaxPageSize, page.PageSize)
+	assertT.Len(page.Keys, 5)
+
+	page = getPage(t, srv, "page=4611686018427387904&size=4611686018427387904")
+	assertT.Empty(page.Keys)
+	assertT.Equal(5, page.TotalKeys)
+
+	resp, err := srv.Client().Get(srv.URL + "?page=0")
+	assertT.Nil(err)
+	resp.Body.Close()
+	assertT.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestEternalTTL(t *testing.T) {
+	em := expiry.NewExpiryMap[int, int]()
+	defer em.Discard()
+	_ = em.Put(1, 1)
+	srv := httptest.NewServer(NewHandler[int, int](em))
+	defer srv.Close()
+
+	page := getPage(t, srv, "format=json")
+	assert.Equal(t, "never", page.ExpireTime)
+	assert.Equal(t, []KeyInfo{{"1", "never"}}, page.Keys)
+}
+
+func TestHTMLView(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := newMap()
+	defer em.Discard()
+	_ = em.Put("<script>", 1)
+	handler := NewHandler[string, int](em)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	handler.ServeHTTP(rec, req)
+	assertT.Equal("text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assertT.Contains(body, "<td>6</td>")
+	assertT.Contains(body, "&lt;script&gt;")
+	assertT.NotContains(body, "<script>")
+	assertT.NotContains(body, "Clear cache")
+
+	handler.WithActions(token)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=html", nil))
+	assertT.Contains(rec.Body.String(), "Clear cache")
+	assertT.Contains(rec.Body.String(), "Invalidate")
+}
+
+func TestActionsDisabled(t *testing.T) {
+	em := newMap()
+	defer em.Discard()
+	srv := httptest.NewServer(NewHandler[string, int](em))
+	defer srv.Close()
+
+	status, _ := post(t, srv, url.Values{"action": {"clear"}}, "")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, 5, em.Len())
+}
+
+func TestActions(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := newMap()
+	defer em.Discard()
+	srv := httptest.NewServer(NewHandler[string, int](em).WithActions(token))
+	defer srv.Close()
+
+	status, _ := post(t, srv, url.Values{"action": {"invalidate"}, "key": {"a"}}, "wrong")
+	assertT.Equal(http.StatusForbidden, status)
+	assertT.True(em.ContainsKey("a"))
+
+	status, result := post(t, srv, url.Values{"action": {"invalidate"}, "key": {"a"}}, token)
+	assertT.Equal(http.StatusOK, status)
+	assertT.Equal(true, result["removed"])
+	assertT.False(em.ContainsKey("a"))
+
+	status, result = post(t, srv, url.Values{"action": {"invalidate"}, "key": {"a"}, TokenField: {token}}, "")
+	assertT.Equal(http.StatusOK, status)
+	assertT.Equal(false, result["removed"])
+
+	status, _ = post(t, srv, url.Values{"action": {"drop"}}, token)
+	assertT.Equal(http.StatusBadRequest, status)
+
+	status, result = post(t, srv, url.Values{"action": {"clear"}}, token)
+	assertT.Equal(http.StatusOK, status)
+	assertT.Equal(4.0, result["removed"])
+	assertT.Equal(0, em.Len())
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	resp, err := srv.Client().Do(req)
+	assertT.Nil(err)
+	resp.Body.Close()
+	assertT.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestKeyParser(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := expiry.NewShardedExpiryMap[int, string](2).
+		WithLoader(func(key int) (string, error) { return strconv.Itoa(key), nil })
+	defer em.Discard()
+	_, _ = em.Get(42)
+	handler := NewHandler[int, string](em).WithActions(token).WithKeyParser(strconv.Atoi)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	status, _ := post(t, srv, url.Values{"action": {"invalidate"}, "key": {"x"}}, token)
+	assertT.Equal(http.StatusBadRequest, status)
+
+	status, result := post(t, srv, url.Values{"action": {"invalidate"}, "key": {"42"}}, token)
+	assertT.Equal(http.StatusOK, status)
+	assertT.Equal(true, result["removed"])
+	assertT.Equal(0, em.Len())
+}
+
+func TestPublish(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := newMap()
+	defer em.Discard()
+	name := "cache_" + strconv.FormatInt(time.Now().UnixNano(), 10) // expvar names can't be reused between test runs
+	Publish[string, int](name, em)
+
+	var summary Summary
+	assertT.Nil(json.Unmarshal([]byte(expvar.Get(name).String()), &summary))
+	assertT.Equal(5, summary.Len)
+	assertT.Equal(10, summary.Capacity)
+
+	rec := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	assertT.Contains(rec.Body.String(), `"`+name+`": {"capacity":10`)
+}
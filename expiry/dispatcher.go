@@ -0,0 +1,287 @@
+package expiry
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aknopov/handymaps/internal/util"
+)
+
+// Policy applied when a queue of undelivered events is full
+type OverflowPolicy int
+
+const (
+	// wait until the queue has room for the event
+	Block OverflowPolicy = iota
+	// discard the event being queued
+	DropNewest
+	// discard the oldest queued event to make room for the new one
+	DropOldest
+)
+
+// Channel subscription to map events
+type subscription[K comparable, V any] struct {
+	lock     sync.Mutex
+	ch       chan Event[K, V]
+	mask     uint64 // bit set of event types
+	overflow OverflowPolicy
+	done     chan struct{}
+	closed   bool
+	once     sync.Once
+	dropped  *atomic.Uint64 // counter of the dispatcher
+}
+
+// Collects events raised while the map is locked and delivers them to listeners after the lock is released.
+// In synchronous mode events are delivered by the goroutine that released the lock,
+// in asynchronous mode - by a dedicated goroutine reading from a bounded queue.
+// Only one goroutine delivers or queues events at a time, so that listeners are invoked one at a time in order of events.
+type dispatcher[K comparable, V any] struct {
+	lock        sync.Mutex
+	listeners   *util.Set[Listener[K, V]]
+	subscribers *util.Set[*subscription[K, V]]
+	pending     []Event[K, V]
+	delivering  bool             // a goroutine is flushing pending events
+	queue       chan Event[K, V] // `nil` in synchronous mode
+	overflow    OverflowPolicy
+	done        chan struct{}
+	watched     atomic.Bool   // there are listeners or subscribers, lets `post` skip the lock otherwise
+	hasPending  atomic.Bool   // `pending` isn't empty, lets `flush` skip the lock otherwise
+	dropped     atomic.Uint64 // events discarded by full queue or subscription channels
+}
+
+func newDispatcher[K comparable, V any]() *dispatcher[K, V] {
+	return &dispatcher[K, V]{
+		listeners:   util.NewSet[Listener[K, V]](),
+		subscribers: util.NewSet[*subscription[K, V]](),
+	}
+}
+
+func (d *dispatcher[K, V]) addListener(listener Listener[K, V]) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.listeners.Add(listener)
+	d.updateWatched()
+}
+
+func (d *dispatcher[K, V]) removeListener(listener Listener[K, V]) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.listeners.Remove(listener)
+	d.updateWatched()
+}
+
+// Must be called with the dispatcher lock
+func (d *dispatcher[K, V]) updateWatched() {
+	d.watched.Store(d.listeners.Size() > 0 || d.subscribers.Size() > 0)
+}
+
+func (d *dispatcher[K, V]) subscribe(bufSize int, overflow OverflowPolicy, filter []EventType) (<-chan Event[K, V], func()) {
+	sub := &subscription[K, V]{
+		ch:       make(chan Event[K, V], bufSize),
+		mask:     ^uint64(0),
+		overflow: overflow,
+		done:     make(chan struct{}),
+		dropped:  &d.dropped,
+	}
+	if len(filter) > 0 {
+		sub.mask = 0
+		for _, ev := range filter {
+			sub.mask |= 1 << ev
+		}
+	}
+
+	d.lock.Lock()
+	d.subscribers.Add(sub)
+	d.updateWatched()
+	d.lock.Unlock()
+
+	return sub.ch, func() {
+		d.lock.Lock()
+		d.subscribers.Remove(sub)
+		d.updateWatched()
+		d.lock.Unlock()
+		sub.close()
+	}
+}
+
+func (d *dispatcher[K, V]) unsubscribeAll() {
+	d.lock.Lock()
+	subs := d.subscribers
+	d.subscribers = util.NewSet[*subscription[K, V]]()
+	d.updateWatched()
+	d.lock.Unlock()
+
+	for sub := range subs.Enum() {
+		sub.close()
+	}
+}
+
+// Queues the event for delivery. Safe to call while holding the map lock.
+func (d *dispatcher[K, V]) post(e Event[K, V]) {
+	if !d.watched.Load() {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.listeners.Size() > 0 || d.subscribers.Size() > 0 {
+		e.Time = time.Now()
+		d.pending = append(d.pending, e)
+		d.hasPending.Store(true)
+	}
+}
+
+// Delivers pending events. Must be called after the map lock is released.
+// If another goroutine is flushing already, or the call comes from a listener, the ongoing flush takes over the events.
+func (d *dispatcher[K, V]) flush() {
+	if !d.hasPending.Load() {
+		return
+	}
+	d.lock.Lock()
+	if d.delivering {
+		d.lock.Unlock()
+		return
+	}
+	d.delivering = true
+	finished := false
+	defer func() {
+		if !finished { // a listener panicked
+			d.lock.Lock()
+			d.delivering = false
+			d.lock.Unlock()
+		}
+	}()
+
+	for len(d.pending) > 0 {
+		events := d.pending
+		d.pending = nil
+		d.hasPending.Store(false)
+		queue, done, overflow := d.queue, d.done, d.overflow
+		d.lock.Unlock()
+
+		for _, e := range events {
+			if queue == nil {
+				d.deliver(e)
+			} else {
+				d.dropped.Add(enqueue(queue, done, overflow, e))
+			}
+		}
+		d.lock.Lock()
+	}
+	d.delivering = false
+	finished = true
+	d.lock.Unlock()
+}
+
+func (d *dispatcher[K, V]) deliver(e Event[K, V]) {
+	d.lock.Lock()
+	listeners := make([]Listener[K, V], 0, d.listeners.Size())
+	for l := range d.listeners.Enum() {
+		listeners = append(listeners, l)
+	}
+	subs := make([]*subscription[K, V], 0, d.subscribers.Size())
+	for sub := range d.subscribers.Enum() {
+		if sub.mask&(1<<e.Type) != 0 {
+			subs = append(subs, sub)
+		}
+	}
+	d.lock.Unlock()
+
+	for _, l := range listeners {
+		l.Listen(e.Type, e.Key, e.Value, e.Err)
+	}
+	for _, sub := range subs {
+		sub.send(e)
+	}
+}
+
+func (sub *subscription[K, V]) send(e Event[K, V]) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if !sub.closed {
+		sub.dropped.Add(enqueue(sub.ch, sub.done, sub.overflow, e))
+	}
+}
+
+// Closes subscription channel. A sender blocked on the channel is released first.
+func (sub *subscription[K, V]) close() {
+	sub.once.Do(func() {
+		close(sub.done)
+		sub.lock.Lock()
+		defer sub.lock.Unlock()
+		sub.closed = true
+		close(sub.ch)
+	})
+}
+
+// Queues the event according to the policy.
+//   - returns number of discarded events
+func enqueue[T any](queue chan T, done chan struct{}, overflow OverflowPolicy, e T) uint64 {
+	var dropped uint64
+	switch overflow {
+	case Block:
+		select {
+		case queue <- e:
+		case <-done:
+		}
+	case DropNewest:
+		select {
+		case queue <- e:
+		default:
+			dropped++
+		}
+	case DropOldest:
+		for {
+			select {
+			case queue <- e:
+				return dropped
+			default:
+			}
+			select {
+			case <-queue:
+				dropped++
+			default:
+			}
+		}
+	}
+	return dropped
+}
+
+// Switches to asynchronous delivery through a queue of the given size.
+func (d *dispatcher[K, V]) startAsync(bufSize int, overflow OverflowPolicy) {
+	d.stop()
+
+	queue := make(chan Event[K, V], bufSize)
+	done := make(chan struct{})
+	d.lock.Lock()
+	d.queue, d.done, d.overflow = queue, done, overflow
+	d.lock.Unlock()
+
+	go func() {
+		for {
+			select {
+			case e := <-queue:
+				d.deliver(e)
+			case <-done:
+				for {
+					select {
+					case e := <-queue:
+						d.deliver(e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stops asynchronous delivery, if any. Queued events are delivered before the dispatching goroutine exits.
+func (d *dispatcher[K, V]) stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.queue != nil {
+		close(d.done)
+		d.queue, d.done = nil, nil
+	}
+}
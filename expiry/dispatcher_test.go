@@ -0,0 +1,314 @@
+package expiry
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	waitTime  = 500 * time.Millisecond
+	sleepTime = 20 * time.Millisecond
+)
+
+func TestListenerCallsBackIntoMap(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+
+	done := make(chan int, 1)
+	em.AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+		if ev == Removed && key == "Hi" {
+			v, _ := em.Get("Hi")
+			done <- v
+		}
+	}})
+
+	_, _ = em.Get("Hi")
+	go func() { _, _ = em.Get("Hello") }()
+
+	select {
+	case v := <-done:
+		assertT.Equal(2, v)
+	case <-time.After(waitTime):
+		t.Fatal("Listener deadlocked")
+	}
+}
+
+func TestSlowListenerDoesNotBlockMap(t *testing.T) {
+	assertT := assert.New(t)
+
+	release := make(chan bool)
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithAsyncListeners(10, Block).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+			<-release
+		}})
+	defer em.Discard()
+
+	done := make(chan bool)
+	go func() {
+		_, _ = em.Get("Hi")
+		_, _ = em.Get("Hello")
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		assertT.Equal(2, em.Len())
+	case <-time.After(waitTime):
+		t.Fatal("Map operations were blocked by listener")
+	}
+	close(release)
+}
+
+func collectAsync(t *testing.T, overflow OverflowPolicy) []string {
+	release := make(chan bool)
+	received := make(chan string, 10)
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithAsyncListeners(1, overflow).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+			<-release
+			received <- key
+		}})
+
+	_, _ = em.Get("A")
+	time.Sleep(sleepTime) // "A" is taken by the dispatcher
+	_, _ = em.Get("B")
+	_, _ = em.Get("C")
+	close(release)
+
+	keys := make([]string, 0)
+	for len(keys) < 2 {
+		select {
+		case key := <-received:
+			keys = append(keys, key)
+		case <-time.After(waitTime):
+			t.Fatal("Events were not delivered")
+		}
+	}
+	return keys
+}
+
+func TestAsyncDropNewest(t *testing.T) {
+	assert.Equal(t, []string{"A", "B"}, collectAsync(t, DropNewest))
+}
+
+func TestAsyncDropOldest(t *testing.T) {
+	assert.Equal(t, []string{"A", "C"}, collectAsync(t, DropOldest))
+}
+
+func TestDiscardDeliversQueuedEvents(t *testing.T) {
+	assertT := assert.New(t)
+
+	received := make(chan EventType, 10)
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithAsyncListeners(10, Block).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+			received <- ev
+		}})
+
+	_, _ = em.Get("Hi")
+	em.Discard()
+
+	assertT.Equal(Added, <-received)
+	assertT.Equal(Removed, <-received)
+}
+
+func TestSubscribe(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	events, cancel := em.Subscribe(10, Added, Removed, Expired)
+	defer cancel()
+
+	start := time.Now()
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+
+	ev := <-events
+	assertT.Equal(Added, ev.Type)
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(2, ev.Value)
+	assertT.Nil(ev.Err)
+	assertT.Equal(CauseNone, ev.Cause)
+	assertT.False(ev.Time.Before(start))
+
+	ev = <-events
+	assertT.Equal(Removed, ev.Type)
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(CauseCapacity, ev.Cause)
+
+	ev = <-events
+	assertT.Equal(Added, ev.Type)
+	assertT.Equal("Hello", ev.Key)
+
+	select {
+	case ev = <-events:
+		assertT.Equal(Expired, ev.Type)
+		assertT.Equal("Hello", ev.Key)
+		assertT.Equal(CauseExpired, ev.Cause)
+	case <-time.After(waitTime):
+		t.Fatal("Expiry event was not received")
+	}
+
+	assertT.False(em.Remove("Hello"))
+}
+
+func TestCancelSubscription(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	events1, cancel1 := em.Subscribe(1)
+	events2, _ := em.Subscribe(1, Added)
+
+	cancel1()
+	cancel1()
+	_, ok := <-events1
+	assertT.False(ok)
+
+	_, _ = em.Get("Hi")
+	em.Discard()
+	ev, ok := <-events2
+	assertT.True(ok)
+	assertT.Equal(Added, ev.Type)
+	_, ok = <-events2
+	assertT.False(ok)
+}
+
+// Returns received keys and number of dropped events
+func collectSubscribed(overflow OverflowPolicy) ([]string, uint64) {
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithSubscriptionOverflow(overflow)
+	events, cancel := em.Subscribe(1)
+
+	_, _ = em.Get("A")
+	_, _ = em.Get("B")
+	_, _ = em.Get("C")
+	cancel()
+
+	keys := make([]string, 0)
+	for ev := range events {
+		keys = append(keys, ev.Key)
+	}
+	return keys, em.Stats().DroppedEvents
+}
+
+func TestSubscriptionDropNewest(t *testing.T) {
+	keys, dropped := collectSubscribed(DropNewest)
+	assert.Equal(t, []string{"A"}, keys)
+	assert.Equal(t, uint64(2), dropped)
+}
+
+func TestSubscriptionDropOldest(t *testing.T) {
+	keys, dropped := collectSubscribed(DropOldest)
+	assert.Equal(t, []string{"C"}, keys)
+	assert.Equal(t, uint64(2), dropped)
+}
+
+func TestSubscriptionBlock(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithSubscriptionOverflow(Block)
+	events, cancel := em.Subscribe(1)
+	defer cancel()
+
+	done := make(chan bool)
+	go func() {
+		_, _ = em.Get("A")
+		_, _ = em.Get("B")
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Get was not blocked by subscriber")
+	case <-time.After(sleepTime):
+	}
+
+	assertT.Equal("A", (<-events).Key)
+	<-done
+	assertT.Equal("B", (<-events).Key)
+}
+
+func TestListenersSerialized(t *testing.T) {
+	assertT := assert.New(t)
+
+	var active, maxActive atomic.Int32
+	var lock sync.Mutex
+	seen := make(map[string][]EventType)
+	em := NewExpiryMap[string, int]().AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+		if n := active.Add(1); n > maxActive.Load() {
+			maxActive.Store(n)
+		}
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		seen[key] = append(seen[key], ev)
+		lock.Unlock()
+		active.Add(-1)
+	}})
+	defer em.Discard()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_ = em.Put(key, j)
+				em.Remove(key)
+			}
+		}("k" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	em.flushEvents()
+
+	assertT.Equal(int32(1), maxActive.Load())
+	for i := 0; i < 8; i++ {
+		evs := seen["k"+strconv.Itoa(i)]
+		assertT.Equal(10, len(evs))
+		for j := range evs {
+			assertT.Equal([]EventType{Added, Removed}[j%2], evs[j])
+		}
+	}
+}
+
+func TestPostWithoutWatchers(t *testing.T) {
+	assertT := assert.New(t)
+
+	d := newDispatcher[string, int]()
+	d.post(Event[string, int]{Type: Added, Key: "Hi"})
+	assertT.False(d.hasPending.Load())
+	assertT.Empty(d.pending)
+
+	events, cancel := d.subscribe(1, DropNewest, nil)
+	d.post(Event[string, int]{Type: Added, Key: "Hi"})
+	assertT.True(d.hasPending.Load())
+	d.flush()
+	assertT.False(d.hasPending.Load())
+	assertT.Equal("Hi", (<-events).Key)
+
+	cancel()
+	assertT.False(d.watched.Load())
+	d.post(Event[string, int]{Type: Added, Key: "Hello"})
+	assertT.Empty(d.pending)
+}
@@ -1,173 +1,560 @@
 package expiry
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/aknopov/handymaps/ordered"
 )
 
+// Error returned by operations on a closed map
+var ErrClosed = errors.New("expiry map is closed")
+
+// Queues the event for listeners. Events are delivered with `flushEvents` once the lock is released.
 func (em *ExpiryMap[K, V]) notifyListeners(ev EventType, key K, val V, err error) {
-	for f := range em.listeners.Enum() {
-		f.Listen(ev, key, val, err)
-	}
+	em.dispatcher.post(Event[K, V]{Type: ev, Key: key, Value: val, Err: err})
+}
+
+func (em *ExpiryMap[K, V]) flushEvents() {
+	em.dispatcher.flush()
 }
 
-func (em *ExpiryMap[K, V]) removeEntry(key K) bool {
+func (em *ExpiryMap[K, V]) removeEntry(key K, cause Cause) bool {
 	if val, ok := em.backMap.Get(key); ok {
-		val.exptmr.Stop()
+		val.stopTimer()
 		em.backMap.Remove(key)
-		em.notifyListeners(Removed, key, val.val, nil)
+		em.tags.remove(key, val.tags)
+		if val.pinned {
+			em.stats.pinned.Add(-1)
+		}
+		em.stats.recordRemoval(cause)
+		if cause == CauseCapacity && em.demote != nil {
+			em.demote(key, val.val, val.tags)
+		}
+		ev := Removed
+		if cause == CauseExpired {
+			ev = Expired
+			if em.maxStale > 0 {
+				em.stale.keep(key, val.val, em.clock.Now(), em.maxStale)
+			}
+		}
+		em.postEntryEvent(Event[K, V]{Type: ev, Key: key, Value: val.val, Cause: cause}, val)
 		return true
 	}
 	return false
 }
 
-func (em *ExpiryMap[K, V]) removeOldest() {
-	keys := em.backMap.Keys()
-	if len(keys) > 0 {
-		em.removeEntry(keys[0])
+// Removes the entry after its time-to-live or to ensure capacity, reporting it to the tracer
+func (em *ExpiryMap[K, V]) evict(ctx context.Context, key K, cause Cause) {
+	if _, ok := em.backMap.Get(key); !ok {
+		return
 	}
+	start := time.Now()
+	_, span := em.tracer.Start(ctx, OpEvict, key)
+	em.removeEntry(key, cause)
+	span.End(OutcomeSuccess, time.Since(start), nil)
 }
 
-func (em *ExpiryMap[K, V]) assumeAlive() {
-	if em.evictChan == nil {
-		panic("The map has been discarded!")
+// Evicts the oldest entry that is not pinned. If `BeforeEvict` hook vetoes eviction, the next entry is tried.
+// Once the allowed number of vetoes is exhausted, the next entry is evicted without asking the hook, and if all entries
+// are vetoed, the oldest one is evicted regardless. Returns `false` if there is no unpinned entry.
+func (em *ExpiryMap[K, V]) removeOldest(ctx context.Context) bool {
+	var oldest K
+	found := false
+	vetoes := 0
+	for _, key := range em.backMap.Keys() {
+		ent, _ := em.backMap.Get(key)
+		if ent.pinned {
+			continue
+		}
+		if !found {
+			oldest, found = key, true
+		}
+		if vetoes < em.maxVetoes && !em.allowEviction(key, ent, CauseCapacity) {
+			vetoes++
+			continue
+		}
+		em.evict(ctx, key, CauseCapacity)
+		return true
 	}
+	if found {
+		em.evict(ctx, oldest, CauseCapacity)
+	}
+	return found
 }
 
-// Removes all entries and stops eviction loop
-func (em *ExpiryMap[K, V]) Discard() {
-	em.assumeAlive()
+// Evicts the oldest entries until no more than `size` entries count against capacity, unless capacity is unlimited. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) ensureCapacity(ctx context.Context, size int) {
+	for em.maxCapacity != Unlimited && em.countedLen() > size {
+		if !em.removeOldest(ctx) {
+			return
+		}
+	}
+}
+
+func (em *ExpiryMap[K, V]) removeAll(cause Cause) {
+	keys := make([]K, em.backMap.Len())
+	copy(keys, em.backMap.Keys())
+	for _, key := range keys {
+		em.removeEntry(key, cause)
+	}
+}
+
+// Returns `true` if the map has been closed
+func (em *ExpiryMap[K, V]) IsClosed() bool {
+	return em.closed.Load()
+}
+
+// Removes all entries, stops eviction loop, flushes pending writes and delivers remaining events. Subscription channels get closed.
+// Subsequent calls have no effect. Always returns `nil`.
+func (em *ExpiryMap[K, V]) Close() error {
+	if !em.closed.CompareAndSwap(false, true) {
+		return nil
+	}
 
-	em.Clear()
 	em.WriteAtomically(func() {
-		em.stopChan <- true
-		close(em.evictChan)
-		em.evictChan = nil
+		em.removeAll(CauseExplicit)
+		close(em.stopChan)
 	})
+	em.detachInvalidator()
+	em.detachJanitor()
+	em.stopWriteBehind()
+	em.flushEvents()
+	em.dispatcher.stop()
+	em.dispatcher.unsubscribeAll()
+	return nil
+}
+
+// Same as `Close`, kept for backward compatibility
+func (em *ExpiryMap[K, V]) Discard() {
+	_ = em.Close()
 }
 
 // Returns a value associated with the given key. It can invoke `load` function if entry is not present in the map.
+// Returns `ErrClosed` if the map has been closed.
 func (em *ExpiryMap[K, V]) Get(key K) (V, error) {
-	em.assumeAlive()
+	return em.GetCtx(context.Background(), key)
+}
 
+// Same as `Get`, but tracer spans of loading and evictions caused by the call are started with the context,
+// so that they attach to the caller's trace. The context doesn't cancel loading.
+func (em *ExpiryMap[K, V]) GetCtx(ctx context.Context, key K) (V, error) {
 	var err error
 	var val V
-	em.MaybeLockForWriting(func() {
-		if ent, ok := em.backMap.Get(key); !ok {
-			val, err = em.loadValue(key)
+	var hit bool
+	em.ReadAtomically(func() {
+		if em.IsClosed() {
+			err = ErrClosed
 		} else {
-			val = ent.val
-			em.notifyListeners(Requested, key, val, nil)
+			val, hit = em.hit(ctx, key)
+			em.recordRequest(key, val)
 		}
 	})
+	if err == nil && !hit {
+		unlocked := false
+		// the entry could be loaded by another goroutine while the lock was released
+		em.MaybeLockForWriting(func() {
+			if em.IsClosed() {
+				err = ErrClosed
+			} else if val, hit = em.hit(ctx, key); !hit {
+				em.stats.misses.Add(1)
+				if unlocked = em.loadsUnlocked(); !unlocked {
+					val, err = em.loadValue(ctx, key, true)
+				}
+			}
+		})
+		if unlocked {
+			val, err = em.loading.do(key, func() (V, error) { return em.loadValue(ctx, key, false) })
+		}
+	}
+	em.flushEvents()
 	return val, err
 }
 
-func (em *ExpiryMap[K, V]) loadValue(key K) (V, error) {
-	val, err := em.loader(key)
+// Returns value of the entry if present, recording the hit. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) hit(ctx context.Context, key K) (V, bool) {
+	ent, ok := em.getEntry(key)
+	if !ok {
+		return ent.val, false
+	}
+	em.stats.hits.Add(1)
+	ent.access.record(time.Now())
+	em.postEntryEvent(Event[K, V]{Type: Requested, Key: key, Value: ent.val}, ent)
+	if em.shouldRefresh(ent) {
+		em.startRefresh(ctx, key)
+	}
+	return ent.val, true
+}
+
+// Loads the value and adds the entry, or serves a stale value if the load fails.
+//   - locked - whether the call holds the upgradable lock, otherwise the entry is added under W-lock, unless the map has been closed meanwhile
+func (em *ExpiryMap[K, V]) loadValue(ctx context.Context, key K, locked bool) (val V, err error) {
+	start := time.Now()
+	spanCtx, span := em.tracer.Start(ctx, OpLoad, key)
+	defer func() {
+		span.End(loadOutcome(err), time.Since(start), err)
+	}()
+
+	write := em.WriteAtomically
+	if locked {
+		write = func(f func()) {
+			em.UpgradeWLock()
+			f()
+		}
+	}
+
+	var tags []string
+	val, tags, err = em.invokeLoader(key)
+	elapsed := time.Since(start)
+	em.stats.recordLoad(elapsed, err)
 	if err == nil {
-		em.UpgradeWLock()
-		for em.maxCapacity != Unlimited && em.backMap.Len() >= em.maxCapacity {
-			em.removeOldest()
-		}
-		keyTimer := time.NewTimer(em.ttl)
-		em.backMap.Put(key, entry[V]{val: val, exptmr: keyTimer})
-		go func() {
-			<-keyTimer.C
-			em.evictChan <- key
-		}()
-		em.notifyListeners(Added, key, val, nil)
+		write(func() {
+			if em.IsClosed() {
+				return
+			}
+			em.purgeExpired(spanCtx, key) // expiry of the entry can be vetoed
+			em.stale.remove(key)
+			em.putEntry(spanCtx, key, val, elapsed, false)
+			em.tagEntry(key, tags)
+		})
 	} else {
-		em.notifyListeners(Failed, key, val, err) // val has "zero" value
+		em.dispatcher.post(Event[K, V]{Type: Failed, Key: key, Value: val, Err: err, Duration: elapsed}) // val has "zero" value
+		if em.passive && em.maxStale > 0 {
+			// expired entry of a passive map gets to the stale area only when it is removed
+			write(func() { em.purgeExpired(spanCtx, key) })
+		}
+		if staleVal, ok := em.stale.get(key, em.clock.Now()); ok {
+			val, err = staleVal, fmt.Errorf("%w: %w", ErrStale, err)
+			em.stats.staleHits.Add(1)
+			em.notifyListeners(ServedStale, key, val, err)
+		}
 	}
 	return val, err
 }
 
+// Adds a new entry evicting the oldest ones if the map is full. Must be called with W-lock.
+//   - loadTime - time taken by the loader to produce the value, zero if it was put directly
+//   - pinned - whether the entry is exempt from expiry and eviction
+func (em *ExpiryMap[K, V]) addEntry(ctx context.Context, key K, val V, loadTime time.Duration, pinned bool) {
+	counted := !pinned || em.pinnedCounted
+	if counted {
+		em.ensureCapacity(ctx, em.maxCapacity-1)
+	}
+	ent := em.newEntry(key, val, loadTime)
+	if pinned {
+		em.pinEntry(&ent)
+	}
+	em.backMap.Put(key, ent)
+	em.postEntryEvent(Event[K, V]{Type: Added, Key: key, Value: val, Duration: loadTime}, ent)
+	if counted && em.maxCapacity == 0 {
+		// map without room keeps no entries
+		em.ensureCapacity(ctx, 0)
+	}
+}
+
+// Replaces value of the entry without changing its expiry time or adds a new entry. Must be called with W-lock.
+//   - pin - whether to pin the entry, if it isn't pinned yet
+func (em *ExpiryMap[K, V]) putEntry(ctx context.Context, key K, val V, loadTime time.Duration, pin bool) {
+	if ent, ok := em.backMap.Get(key); ok {
+		ent.val = val
+		if pin && !ent.pinned {
+			em.pinEntry(&ent)
+		}
+		em.backMap.Put(key, ent)
+		em.postEntryEvent(Event[K, V]{Type: Replaced, Key: key, Value: val}, ent)
+	} else {
+		em.addEntry(ctx, key, val, loadTime, pin)
+	}
+}
+
+// Creates entry with a new expiry timer. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) newEntry(key K, val V, loadTime time.Duration) entry[V] {
+	ent := entry[V]{val: val, loadTime: loadTime, created: time.Now(), access: &accessStats{}}
+	em.schedule(key, &ent, em.entryTTL())
+	return ent
+}
+
+// Sets expiry time of the entry to `ttl` after its creation, replacing its expiry timer. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) schedule(key K, ent *entry[V], ttl time.Duration) {
+	var expires time.Time
+	if ttl != Eternity {
+		expires = ent.created.Add(ttl)
+	}
+	em.scheduleAt(key, ent, expires)
+}
+
+// Sets expiry time of the entry, replacing its expiry timer. Zero time means no expiry. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) scheduleAt(key K, ent *entry[V], expires time.Time) {
+	ent.stopTimer()
+	em.nextGen++
+	gen := em.nextGen
+	ent.gen, ent.exptmr, ent.expires = gen, nil, expires
+	if expires.IsZero() {
+		return
+	}
+	if !em.passive {
+		ent.exptmr = time.AfterFunc(time.Until(ent.expires), func() {
+			select {
+			case em.evictChan <- expiration[K]{key, gen}:
+			case <-em.stopChan:
+			}
+		})
+	}
+}
+
+// Writes through to the writer, if any. Failure is reported to listeners.
+func (em *ExpiryMap[K, V]) writeThrough(key K, val V, delete bool) error {
+	if em.writer == nil || em.behind != nil {
+		return nil
+	}
+	var err error
+	if delete {
+		err = em.writer.Delete(key)
+	} else {
+		err = em.writer.Write(key, val)
+	}
+	if err != nil {
+		em.notifyListeners(WriteFailed, key, val, err)
+	}
+	return err
+}
+
+func (em *ExpiryMap[K, V]) writeBehind(key K, val V, delete bool) {
+	if em.behind != nil {
+		em.behind.enqueue(key, val, delete)
+	}
+}
+
+// Associates the value with the key. A new entry gets full time-to-live, while replacing the value of
+// an existing entry doesn't change its expiry time. The value is propagated to the writer, if configured.
+//   - returns write-through error or `ErrClosed` if the map has been closed
+func (em *ExpiryMap[K, V]) Put(key K, val V) error {
+	return em.put(key, val, false)
+}
+
+// Same as `Put`, but pins the entry, if it isn't pinned yet
+func (em *ExpiryMap[K, V]) put(key K, val V, pin bool) error {
+	var err error
+	em.WriteAtomically(func() {
+		if em.IsClosed() {
+			err = ErrClosed
+			return
+		}
+		if err = em.writeThrough(key, val, false); err != nil {
+			return
+		}
+		em.purgeExpired(context.Background(), key)
+		em.stale.remove(key)
+		em.putEntry(context.Background(), key, val, 0, pin)
+		em.writeBehind(key, val, false)
+	})
+	em.flushEvents()
+	return err
+}
+
+// Writes pending write-behind operations immediately. Failed writes remain queued for retry.
+func (em *ExpiryMap[K, V]) Flush() {
+	if behind := em.writeBehindQueue(); behind != nil {
+		behind.flush(true, false)
+	}
+	em.flushEvents()
+}
+
+// Returns number of write-behind operations that are not flushed yet
+func (em *ExpiryMap[K, V]) PendingWrites() int {
+	behind := em.writeBehindQueue()
+	if behind == nil {
+		return 0
+	}
+	return behind.size()
+}
+
+func (em *ExpiryMap[K, V]) writeBehindQueue() *writeBehind[K, V] {
+	var behind *writeBehind[K, V]
+	em.ReadAtomically(func() {
+		behind = em.behind
+	})
+	return behind
+}
+
 // Returns the value associated to the given key. In contrast to `Get()` this method does not trigger the loader.
 func (em *ExpiryMap[K, V]) Peek(key K) (V, bool) {
-	em.assumeAlive()
-
 	var ent entry[V]
 	var ok bool
 	em.ReadAtomically(func() {
-		ent, ok = em.backMap.Get(key)
+		if em.IsClosed() {
+			return
+		}
+		ent, ok = em.getEntry(key)
 		if ok {
-			em.notifyListeners(Requested, key, ent.val, nil)
+			em.stats.hits.Add(1)
+			ent.access.record(time.Now())
+			em.postEntryEvent(Event[K, V]{Type: Requested, Key: key, Value: ent.val}, ent)
 		} else {
+			em.stats.misses.Add(1)
 			em.notifyListeners(Missed, key, ent.val, nil)
 		}
+		em.recordRequest(key, ent.val)
 	})
+	em.flushEvents()
 	return ent.val, ok
 }
 
 // Returns `true`, if there is a mapping for the specified key.
 func (em *ExpiryMap[K, V]) ContainsKey(key K) bool {
-	em.assumeAlive()
-
 	var ok bool
 	em.ReadAtomically(func() {
-		_, ok = em.backMap.Get(key)
+		_, ok = em.getEntry(key)
 	})
 	return ok
 }
 
-// Replaces synchronously the entry for a key if present. This operationresets doesn't change the expiry time.
+// Returns time left until the entry expires, or `Eternity` if the entry doesn't expire. Neither loader nor listeners are invoked.
+//   - the second value is `false` if there is no mapping for the key
+func (em *ExpiryMap[K, V]) RemainingTTL(key K) (time.Duration, bool) {
+	var ent entry[V]
+	var ok bool
+	em.ReadAtomically(func() {
+		ent, ok = em.getEntry(key)
+	})
+	if !ok {
+		return 0, false
+	}
+	return ent.remainingTTL(), true
+}
+
+// Returns a list of the map keys in the order they were inserted.
+func (em *ExpiryMap[K, V]) Keys() []K {
+	var keys []K
+	em.ReadAtomically(func() {
+		keys = em.liveKeys()
+	})
+	return keys
+}
+
+// Returns a point-in-time copy of the map entries in the order they were inserted.
+// The copy is not affected by subsequent changes of the map.
+func (em *ExpiryMap[K, V]) Snapshot() *ordered.OrderedMap[K, V] {
+	var snapshot *ordered.OrderedMap[K, V]
+	em.ReadAtomically(func() {
+		keys := em.liveKeys()
+		snapshot = ordered.NewOrderedMapEx[K, V](len(keys))
+		for _, k := range keys {
+			ent, _ := em.backMap.Get(k)
+			snapshot.Put(k, ent.val)
+		}
+	})
+	return snapshot
+}
+
+// Calls `f` sequentially for each entry present in the map at the time of the call. Iteration stops if `f` returns `false`.
+// Neither loader nor listeners are invoked, and `f` may safely call back into the map.
+func (em *ExpiryMap[K, V]) Range(f func(key K, val V) bool) {
+	it := em.Snapshot().Iterator()
+	for it.HasNext() {
+		if !f(it.Next()) {
+			break
+		}
+	}
+}
+
+// Replaces synchronously the entry for a key if present. This operation doesn't change the expiry time.
+// The value is propagated to the writer and other instances are told to drop the key, if configured.
 //
 //   - return `true` if value was replaced
 func (em *ExpiryMap[K, V]) Replace(key K, val V) bool {
-	em.assumeAlive()
-
 	var ok bool
-	em.ReadAtomically(func() {
-		if ent, oki := em.backMap.Get(key); oki {
-			em.backMap.Put(key, entry[V]{val: val, exptmr: ent.exptmr})
-			em.notifyListeners(Replaced, key, val, nil)
+	em.WriteAtomically(func() {
+		em.purgeExpired(context.Background(), key)
+		if ent, oki := em.backMap.Get(key); oki && em.writeThrough(key, val, false) == nil {
+			ent.val = val
+			em.backMap.Put(key, ent)
+			em.postEntryEvent(Event[K, V]{Type: Replaced, Key: key, Value: val}, ent)
+			em.writeBehind(key, val, false)
 			ok = true
 		}
 	})
+	if ok {
+		em.publishInvalidation(key, false)
+	}
+	em.flushEvents()
 	return ok
 }
 
-// Removes the mapping for a key from the cache if it is present.
+// Removes the mapping for a key from the cache if it is present. Deletion is propagated to the writer
+// and to other instances, if configured, even if the key is not in the cache.
 //
 //   - return `true` if value was removed
 func (em *ExpiryMap[K, V]) Remove(key K) bool {
-	em.assumeAlive()
-
-	var ok bool
-	em.ReadAtomically(func() {
-		ok = em.removeEntry(key)
+	var ok, changed bool
+	em.WriteAtomically(func() {
+		var zero V
+		if em.IsClosed() || em.writeThrough(key, zero, true) != nil {
+			return
+		}
+		em.purgeExpired(context.Background(), key)
+		ok = em.removeEntry(key, CauseExplicit)
+		em.stale.remove(key)
+		em.writeBehind(key, zero, true)
+		changed = true
 	})
+	if changed {
+		em.publishInvalidation(key, false)
+	}
+	em.flushEvents()
 	return ok
 }
 
-// Clears the cache.
+// Clears the cache. Other instances are told to clear their entries, if configured.
 func (em *ExpiryMap[K, V]) Clear() {
-	em.assumeAlive()
+	em.clear()
+	if !em.IsClosed() {
+		var zero K
+		em.publishInvalidation(zero, true)
+	}
+	em.flushEvents()
+}
 
+func (em *ExpiryMap[K, V]) clear() {
 	em.WriteAtomically(func() {
-		keys := em.backMap.Keys()
-		for _, key := range keys {
-			em.removeEntry(key)
-		}
+		em.removeAll(CauseExplicit)
+		em.stale.clear()
 	})
 }
 
-// Adds listener to ExpiryMap events. The listeners are executed in a synchronous mode in order of their insretion.
+// Adds listener to ExpiryMap events. Listeners are invoked after the map lock is released, so they can call back into the map.
+// Events are delivered one at a time in the order they were raised. By default they are delivered by the goroutine that caused them,
+// unless another goroutine is delivering events already, which then delivers them too - see `WithAsyncListeners` for an alternative.
 func (em *ExpiryMap[K, V]) AddListener(listener Listener[K, V]) *ExpiryMap[K, V] {
-	em.assumeAlive()
-
-	em.ReadAtomically(func() {
-		em.listeners.Add(listener)
-	})
+	em.dispatcher.addListener(listener)
 	return em
 }
 
 // Removes listener to ExpiryMap events.
 func (em *ExpiryMap[K, V]) RemoveListener(listener Listener[K, V]) *ExpiryMap[K, V] {
-	em.assumeAlive()
-
-	em.ReadAtomically(func() {
-		em.listeners.Remove(listener)
-	})
+	em.dispatcher.removeListener(listener)
 	return em
 }
+
+// Subscribes to ExpiryMap events through a channel. Slow subscribers are handled
+// according to the policy set with `WithSubscriptionOverflow`.
+//   - bufSize - channel buffer size
+//   - filter - event types of interest; all events are sent if omitted
+//   - returns the channel and a function that cancels subscription and closes the channel
+//
+// The channel of a closed map is closed already.
+func (em *ExpiryMap[K, V]) Subscribe(bufSize int, filter ...EventType) (<-chan Event[K, V], func()) {
+	return em.SubscribeWithOverflow(bufSize, em.subOverflow, filter...)
+}
+
+// Same as `Subscribe`, but the subscriber is handled according to the given policy, e.g. `Block` for a subscriber
+// that must not miss events.
+func (em *ExpiryMap[K, V]) SubscribeWithOverflow(bufSize int, overflow OverflowPolicy, filter ...EventType) (<-chan Event[K, V], func()) {
+	ch, cancel := em.dispatcher.subscribe(bufSize, overflow, filter)
+	if em.IsClosed() {
+		cancel()
+	}
+	return ch, cancel
+}
@@ -1,6 +1,8 @@
 package expiry
 
 import (
+	"context"
+	"io"
 	"strconv"
 	"testing"
 	"time"
@@ -22,7 +24,56 @@ func TestDiscard(t *testing.T) {
 	em.Discard()
 	assertT.Equal(0, em.Len())
 
-	assertT.Panics(func() { _, _ = em.Get("Hi") })
+	_, err := em.Get("Hi")
+	assertT.ErrorIs(err, ErrClosed)
+	assertT.NotPanics(em.Discard)
+}
+
+var _ io.Closer = NewExpiryMap[string, int]()
+
+func TestClose(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	_, _ = em.Get("Hi")
+	assertT.False(em.IsClosed())
+
+	assertT.Nil(em.Close())
+	assertT.True(em.IsClosed())
+	assertT.Nil(em.Close())
+
+	v, err := em.Get("Hi")
+	assertT.Equal(0, v)
+	assertT.ErrorIs(err, ErrClosed)
+	v, ok := em.Peek("Hi")
+	assertT.Equal(0, v)
+	assertT.False(ok)
+	assertT.False(em.ContainsKey("Hi"))
+	assertT.False(em.Replace("Hi", 3))
+	assertT.False(em.Remove("Hi"))
+	assertT.NotPanics(em.Clear)
+	assertT.Equal(0, em.Len())
+
+	events, _ := em.Subscribe(1)
+	_, ok = <-events
+	assertT.False(ok)
+}
+
+func TestCloseWithContext(t *testing.T) {
+	assertT := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	em := NewExpiryMapWithContext[string, int](ctx).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	_, _ = em.Get("Hi")
+	assertT.Equal(1, em.Len())
+
+	cancel()
+	assertT.Eventually(em.IsClosed, waitTime, sleepTime)
+	assertT.Equal(0, em.Len())
+	_, err := em.Get("Hi")
+	assertT.ErrorIs(err, ErrClosed)
 }
 
 func TestGet(t *testing.T) {
@@ -84,6 +135,34 @@ func TestContainsKey(t *testing.T) {
 	assertT.True(em.ContainsKey("Hi"))
 }
 
+func TestRemainingTTL(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		ExpireAfter(time.Hour)
+	defer em.Discard()
+
+	_, ok := em.RemainingTTL("Hi")
+	assertT.False(ok)
+
+	em.Put("Hi", 2)
+	ttl, ok := em.RemainingTTL("Hi")
+	assertT.True(ok)
+	assertT.LessOrEqual(ttl, time.Hour)
+	assertT.Greater(ttl, 59*time.Minute)
+
+	em.Replace("Hi", 3)
+	ttl2, _ := em.RemainingTTL("Hi")
+	assertT.LessOrEqual(ttl2, ttl)
+
+	eternal := NewExpiryMap[string, int]()
+	defer eternal.Discard()
+	eternal.Put("Hi", 2)
+	ttl, ok = eternal.RemainingTTL("Hi")
+	assertT.True(ok)
+	assertT.Equal(time.Duration(Eternity), ttl)
+}
+
 func TestCapacity(t *testing.T) {
 	assertT := assert.New(t)
 
@@ -216,6 +295,24 @@ func TestClear(t *testing.T) {
 	assertT.Equal(0, em.Len())
 }
 
+// Removal used to iterate over the live key slice of the backing map, skipping every other key
+func TestClearRemovesOddNumberOfEntries(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]()
+	defer em.Discard()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_ = em.Put(key, 1)
+	}
+	events, cancel := em.Subscribe(10, Removed)
+	defer cancel()
+
+	em.Clear()
+	assertT.Equal(0, em.Len())
+	assertT.Empty(em.Keys())
+	assertT.Equal(5, len(events))
+}
+
 func TestExpiry(t *testing.T) {
 	assertT := assert.New(t)
 
@@ -253,21 +350,21 @@ func TestListeners(t *testing.T) {
 	var wrapper2 = ListenerWarapper{listener2}
 
 	em.AddListener(&wrapper1)
-	assertT.Equal(1, em.listeners.Size())
-	assertT.True(em.listeners.Contains(&wrapper1))
+	assertT.Equal(1, em.dispatcher.listeners.Size())
+	assertT.True(em.dispatcher.listeners.Contains(&wrapper1))
 
 	em.AddListener(&wrapper1)
-	assertT.Equal(1, em.listeners.Size())
-	assertT.True(em.listeners.Contains(&wrapper1))
+	assertT.Equal(1, em.dispatcher.listeners.Size())
+	assertT.True(em.dispatcher.listeners.Contains(&wrapper1))
 
 	em.AddListener(&wrapper2)
-	assertT.Equal(2, em.listeners.Size())
-	assertT.True(em.listeners.Contains(&wrapper2))
+	assertT.Equal(2, em.dispatcher.listeners.Size())
+	assertT.True(em.dispatcher.listeners.Contains(&wrapper2))
 
 	em.RemoveListener(&wrapper1)
-	assertT.Equal(1, em.listeners.Size())
-	assertT.False(em.listeners.Contains(&wrapper1))
-	assertT.True(em.listeners.Contains(&wrapper2))
+	assertT.Equal(1, em.dispatcher.listeners.Size())
+	assertT.False(em.dispatcher.listeners.Contains(&wrapper1))
+	assertT.True(em.dispatcher.listeners.Contains(&wrapper2))
 }
 
 func BenchmarkExpiryMap(b *testing.B) {
@@ -284,3 +381,90 @@ func BenchmarkExpiryMap(b *testing.B) {
 		_, _ = em.Get(iS) // <- shouldn't triggert loading
 	}
 }
+
+// Hits of a map without listeners and subscribers. Run with `-cpu 1,4,8` to see lock contention.
+func BenchmarkParallelHits(b *testing.B) {
+	em := NewExpiryMap[int, int]()
+	defer em.Discard()
+	for i := 0; i < 1024; i++ {
+		em.Put(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = em.Get(i % 1024)
+			_, _ = em.Peek(i % 1024)
+			i++
+		}
+	})
+}
+
+func TestKeys(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	assertT.Empty(em.Keys())
+
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+	_, _ = em.Get("World!")
+	keys := em.Keys()
+	assertT.Equal([]string{"Hi", "Hello", "World!"}, keys)
+
+	em.Remove("Hi")
+	assertT.Equal([]string{"Hi", "Hello", "World!"}, keys)
+	assertT.Equal([]string{"Hello", "World!"}, em.Keys())
+}
+
+func TestSnapshot(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+
+	snapshot := em.Snapshot()
+	em.Replace("Hi", 7)
+	em.Clear()
+
+	assertT.Equal(2, snapshot.Len())
+	assertT.Equal([]string{"Hi", "Hello"}, snapshot.Keys())
+	v, _ := snapshot.Get("Hi")
+	assertT.Equal(2, v)
+	v, _ = snapshot.Get("Hello")
+	assertT.Equal(5, v)
+}
+
+func TestRange(t *testing.T) {
+	assertT := assert.New(t)
+
+	events := 0
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) { events++ }})
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+	_, _ = em.Get("World!")
+	events = 0
+
+	visited := make(map[string]int)
+	em.Range(func(key string, val int) bool {
+		visited[key] = val
+		return true
+	})
+	assertT.Equal(map[string]int{"Hi": 2, "Hello": 5, "World!": 6}, visited)
+	assertT.Equal(0, events)
+
+	count := 0
+	em.Range(func(key string, val int) bool {
+		count++
+		em.Remove(key)
+		return count < 2
+	})
+	assertT.Equal(2, count)
+	assertT.Equal([]string{"World!"}, em.Keys())
+}
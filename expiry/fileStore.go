@@ -0,0 +1,97 @@
+package expiry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const fileStoreExt = ".gob"
+
+// Secondary store that keeps each entry in a separate file of a directory. Values are encoded with `encoding/gob`,
+// hence value types should be gob-encodable. File names are derived from the hash of the key's `%#v` representation.
+type FileStore[K comparable, V any] struct {
+	dir string
+}
+
+// Creates file store in the given directory. The directory is created if it doesn't exist.
+func NewFileStore[K comparable, V any](dir string) (*FileStore[K, V], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore[K, V]{dir: dir}, nil
+}
+
+func (store *FileStore[K, V]) path(key K) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", key)))
+	return filepath.Join(store.dir, hex.EncodeToString(sum[:])+fileStoreExt)
+}
+
+// Returns the value stored for the key; the second value is `false` if the key is not present
+func (store *FileStore[K, V]) Get(key K) (V, bool, error) {
+	var val V
+	data, err := os.ReadFile(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return val, false, nil
+	} else if err != nil {
+		return val, false, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&val); err != nil {
+		return val, false, err
+	}
+	return val, true, nil
+}
+
+// Stores the value for the key. The file is replaced atomically.
+func (store *FileStore[K, V]) Put(key K, val V) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(store.dir, "put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), store.path(key))
+}
+
+// Deletes the key; deleting an absent key is not an error
+func (store *FileStore[K, V]) Delete(key K) error {
+	err := os.Remove(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Returns number of stored entries
+func (store *FileStore[K, V]) Len() (int, error) {
+	matches, err := filepath.Glob(filepath.Join(store.dir, "*"+fileStoreExt))
+	return len(matches), err
+}
+
+// Deletes all stored entries
+func (store *FileStore[K, V]) Clear() error {
+	matches, err := filepath.Glob(filepath.Join(store.dir, "*"+fileStoreExt))
+	for i := 0; err == nil && i < len(matches); i++ {
+		if err = os.Remove(matches[i]); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package expiry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	Name  string
+	Count int
+}
+
+func TestFileStore(t *testing.T) {
+	assertT := assert.New(t)
+
+	store, err := NewFileStore[string, record](t.TempDir())
+	assertT.Nil(err)
+
+	_, ok, err := store.Get("Hi")
+	assertT.Nil(err)
+	assertT.False(ok)
+
+	assertT.Nil(store.Put("Hi", record{"Hi", 2}))
+	assertT.Nil(store.Put("Hello", record{"Hello", 5}))
+	assertT.Nil(store.Put("Hi", record{"Hi", 3}))
+	n, err := store.Len()
+	assertT.Nil(err)
+	assertT.Equal(2, n)
+
+	val, ok, err := store.Get("Hi")
+	assertT.Nil(err)
+	assertT.True(ok)
+	assertT.Equal(record{"Hi", 3}, val)
+
+	assertT.Nil(store.Delete("Hi"))
+	assertT.Nil(store.Delete("Hi"))
+	_, ok, _ = store.Get("Hi")
+	assertT.False(ok)
+
+	assertT.Nil(store.Clear())
+	n, _ = store.Len()
+	assertT.Equal(0, n)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	assertT := assert.New(t)
+
+	dir := t.TempDir()
+	store1, _ := NewFileStore[int, string](dir)
+	assertT.Nil(store1.Put(42, "answer"))
+
+	store2, _ := NewFileStore[int, string](dir)
+	val, ok, err := store2.Get(42)
+	assertT.Nil(err)
+	assertT.True(ok)
+	assertT.Equal("answer", val)
+}
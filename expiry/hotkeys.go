@@ -0,0 +1,266 @@
+package expiry
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aknopov/handymaps/internal/util"
+)
+
+const (
+	sketchDepth    = 4    // rows of Count-Min sketch
+	sketchSlices   = 4    // sub-windows the sliding window is split into
+	minSketchWidth = 1024 // counters per row
+)
+
+// Estimated number of requests of a key within the sliding window
+type KeyFrequency[K comparable] struct {
+	Key      K
+	Requests uint64
+}
+
+// Min-heap of the most requested keys
+type topKeys[K comparable] struct {
+	items []KeyFrequency[K]
+	index map[K]int
+}
+
+func (tk *topKeys[K]) Len() int           { return len(tk.items) }
+func (tk *topKeys[K]) Less(i, j int) bool { return tk.items[i].Requests < tk.items[j].Requests }
+func (tk *topKeys[K]) Swap(i, j int) {
+	tk.items[i], tk.items[j] = tk.items[j], tk.items[i]
+	tk.index[tk.items[i].Key] = i
+	tk.index[tk.items[j].Key] = j
+}
+func (tk *topKeys[K]) Push(x any) {
+	item := x.(KeyFrequency[K])
+	tk.index[item.Key] = len(tk.items)
+	tk.items = append(tk.items, item)
+}
+func (tk *topKeys[K]) Pop() any {
+	item := tk.items[len(tk.items)-1]
+	tk.items = tk.items[:len(tk.items)-1]
+	delete(tk.index, item.Key)
+	return item
+}
+
+// Frequency sketch of requested keys - Count-Min sketch over a sliding window and a heap of the most requested keys.
+// The window slides by dropping the oldest of its slices. Has its own lock, so that requests are recorded under map's R-lock.
+type hotKeyTracker[K comparable] struct {
+	lock     sync.Mutex
+	hash     func(K) uint64
+	slices   [][]uint32 // `sketchDepth` rows of counters per slice
+	mask     uint64
+	current  int
+	sliceLen time.Duration
+	sliceEnd time.Time
+	window   time.Duration
+	k        int
+	top      topKeys[K]
+}
+
+func newHotKeyTracker[K comparable](k int, window time.Duration, now time.Time) *hotKeyTracker[K] {
+	width := minSketchWidth
+	for width < 16*k {
+		width <<= 1
+	}
+	slices := make([][]uint32, sketchSlices)
+	for i := range slices {
+		slices[i] = make([]uint32, sketchDepth*width)
+	}
+	sliceLen := window / sketchSlices
+	if sliceLen <= 0 {
+		sliceLen = 1
+	}
+	return &hotKeyTracker[K]{
+		hash:     util.NewHasher[K](),
+		slices:   slices,
+		mask:     uint64(width - 1),
+		sliceLen: sliceLen,
+		sliceEnd: now.Add(sliceLen),
+		window:   sliceLen * sketchSlices,
+		k:        k,
+		top:      topKeys[K]{index: make(map[K]int, k+1)},
+	}
+}
+
+// Returns position of the key counter in the row
+func (hk *hotKeyTracker[K]) cell(h uint64, row int) uint64 {
+	// double hashing - rows differ by a multiple of the upper half of the hash
+	return uint64(row)*(hk.mask+1) + ((h + uint64(row)*(h>>32|1)) & hk.mask)
+}
+
+// Returns estimated number of requests within the window. Must be called with tracker lock.
+func (hk *hotKeyTracker[K]) estimate(h uint64) uint64 {
+	var est uint64
+	for row := 0; row < sketchDepth; row++ {
+		var sum uint64
+		for _, slice := range hk.slices {
+			sum += uint64(slice[hk.cell(h, row)])
+		}
+		if row == 0 || sum < est {
+			est = sum
+		}
+	}
+	return est
+}
+
+// Drops slices that left the window. Must be called with tracker lock.
+func (hk *hotKeyTracker[K]) advance(now time.Time) {
+	if now.Before(hk.sliceEnd) {
+		return
+	}
+	if now.Sub(hk.sliceEnd) >= hk.window {
+		for _, slice := range hk.slices {
+			clearCounters(slice)
+		}
+		hk.sliceEnd = now.Add(hk.sliceLen)
+	} else {
+		for !now.Before(hk.sliceEnd) {
+			hk.current = (hk.current + 1) % sketchSlices
+			clearCounters(hk.slices[hk.current])
+			hk.sliceEnd = hk.sliceEnd.Add(hk.sliceLen)
+		}
+	}
+
+	// counts of the top keys decreased, some could vanish
+	items := hk.top.items[:0]
+	for _, item := range hk.top.items {
+		if item.Requests = hk.estimate(hk.hash(item.Key)); item.Requests > 0 {
+			items = append(items, item)
+		} else {
+			delete(hk.top.index, item.Key)
+		}
+	}
+	hk.top.items = items
+	for i, item := range items {
+		hk.top.index[item.Key] = i
+	}
+	heap.Init(&hk.top)
+}
+
+func clearCounters(counters []uint32) {
+	for i := range counters {
+		counters[i] = 0
+	}
+}
+
+// Records request of the key.
+//   - returns estimated number of requests within the window before and after the request
+func (hk *hotKeyTracker[K]) record(key K, now time.Time) (uint64, uint64) {
+	hk.lock.Lock()
+	defer hk.lock.Unlock()
+	hk.advance(now)
+
+	h := hk.hash(key)
+	before := hk.estimate(h)
+	slice := hk.slices[hk.current]
+	for row := 0; row < sketchDepth; row++ {
+		if i := hk.cell(h, row); slice[i] < ^uint32(0) {
+			slice[i]++
+		}
+	}
+	est := hk.estimate(h)
+
+	if i, ok := hk.top.index[key]; ok {
+		hk.top.items[i].Requests = est
+		heap.Fix(&hk.top, i)
+	} else if hk.top.Len() < hk.k {
+		heap.Push(&hk.top, KeyFrequency[K]{key, est})
+	} else if hk.top.items[0].Requests < est {
+		heap.Pop(&hk.top)
+		heap.Push(&hk.top, KeyFrequency[K]{key, est})
+	}
+	return before, est
+}
+
+// Returns estimated number of requests of the key within the window
+func (hk *hotKeyTracker[K]) frequency(key K, now time.Time) uint64 {
+	hk.lock.Lock()
+	defer hk.lock.Unlock()
+	hk.advance(now)
+	return hk.estimate(hk.hash(key))
+}
+
+// Returns up to `n` most requested keys, the most requested first
+func (hk *hotKeyTracker[K]) topKeys(n int, now time.Time) []KeyFrequency[K] {
+	hk.lock.Lock()
+	defer hk.lock.Unlock()
+	hk.advance(now)
+	return topOf(append([]KeyFrequency[K]{}, hk.top.items...), n)
+}
+
+// Sorts keys by decreasing number of requests and keeps the first `n`
+func topOf[K comparable](keys []KeyFrequency[K], n int) []KeyFrequency[K] {
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Requests > keys[j].Requests })
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// Enables tracking of the most requested keys, including misses, over a sliding window - see `TopKeys`.
+// Requests are `Get` and `Peek` calls. Request counts are estimated with a Count-Min sketch, hence may be overestimated
+// for rarely requested keys, but not underestimated.
+//   - k - number of tracked keys, 0 disables tracking
+//   - window - period over which requests are counted
+func (em *ExpiryMap[K, V]) WithHotKeys(k int, window time.Duration) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		if k <= 0 || window <= 0 {
+			em.hotKeys = nil
+		} else {
+			em.hotKeys = newHotKeyTracker[K](k, window, em.clock.Now())
+		}
+	})
+	return em
+}
+
+// Sets request rate per second at which a key becomes hot. A `HotKey` event is raised when request rate of the key
+// within the window reaches the rate. The event carries the current value, if the key is present.
+// Has effect only while tracking is enabled with `WithHotKeys`. 0 disables the events.
+func (em *ExpiryMap[K, V]) WithHotKeyRate(rate float64) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.hotKeyRate = rate
+	})
+	return em
+}
+
+// Returns up to `n` most requested keys within the window, the most requested first.
+// Returns `nil` unless tracking is enabled with `WithHotKeys`.
+func (em *ExpiryMap[K, V]) TopKeys(n int) []KeyFrequency[K] {
+	var hotKeys *hotKeyTracker[K]
+	em.ReadAtomically(func() {
+		hotKeys = em.hotKeys
+	})
+	if hotKeys == nil {
+		return nil
+	}
+	return hotKeys.topKeys(n, em.clock.Now())
+}
+
+// Returns estimated number of requests of the key within the window, e.g. for admission decisions.
+// Returns 0 unless tracking is enabled with `WithHotKeys`.
+func (em *ExpiryMap[K, V]) Frequency(key K) uint64 {
+	var hotKeys *hotKeyTracker[K]
+	em.ReadAtomically(func() {
+		hotKeys = em.hotKeys
+	})
+	if hotKeys == nil {
+		return 0
+	}
+	return hotKeys.frequency(key, em.clock.Now())
+}
+
+// Records request of the key if tracking is enabled. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) recordRequest(key K, val V) {
+	if em.hotKeys == nil {
+		return
+	}
+	before, after := em.hotKeys.record(key, em.clock.Now())
+	threshold := em.hotKeyRate * em.hotKeys.window.Seconds()
+	if em.hotKeyRate > 0 && float64(before) < threshold && float64(after) >= threshold {
+		em.notifyListeners(HotKey, key, val, nil)
+	}
+}
@@ -0,0 +1,137 @@
+package expiry
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopKeys(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().WithHotKeys(3, time.Minute)
+	defer em.Discard()
+	_ = em.Put("a", 1)
+
+	for i := 0; i < 100; i++ {
+		_, _ = em.Get("k" + strconv.Itoa(i)) // misses count
+	}
+	for i := 0; i < 10; i++ {
+		_, _ = em.Get("x")
+		_, _ = em.Peek("a")
+	}
+	for i := 0; i < 5; i++ {
+		_, _ = em.Get("y")
+	}
+
+	top := em.TopKeys(2)
+	assertT.Equal(2, len(top))
+	assertT.ElementsMatch([]string{"x", "a"}, []string{top[0].Key, top[1].Key})
+	assertT.GreaterOrEqual(top[0].Requests, uint64(10))
+	assertT.Equal(3, len(em.TopKeys(10)))
+	assertT.Equal("y", em.TopKeys(10)[2].Key)
+	assertT.GreaterOrEqual(em.Frequency("y"), uint64(5))
+	assertT.Equal(uint64(0), em.Frequency("z"))
+}
+
+func TestTopKeysDisabled(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]()
+	defer em.Discard()
+	_, _ = em.Get("a")
+
+	assertT.Nil(em.TopKeys(10))
+	assertT.Equal(uint64(0), em.Frequency("a"))
+
+	em.WithHotKeys(10, time.Minute).WithHotKeys(0, time.Minute)
+	_, _ = em.Get("a")
+	assertT.Nil(em.TopKeys(10))
+}
+
+func TestTopKeysSlidingWindow(t *testing.T) {
+	assertT := assert.New(t)
+
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().WithClock(clock).WithHotKeys(5, 4*time.Second)
+	defer em.Discard()
+
+	for i := 0; i < 4; i++ {
+		_, _ = em.Get("old")
+	}
+	clock.Advance(2 * time.Second)
+	_, _ = em.Get("new")
+	assertT.Equal(uint64(4), em.Frequency("old"))
+
+	clock.Advance(3 * time.Second) // requests of "old" left the window
+	assertT.Equal(uint64(0), em.Frequency("old"))
+	assertT.Equal(uint64(1), em.Frequency("new"))
+	assertT.Equal([]KeyFrequency[string]{{"new", 1}}, em.TopKeys(5))
+
+	clock.Advance(time.Hour)
+	assertT.Empty(em.TopKeys(5))
+}
+
+func TestHotKeysClockSetAfter(t *testing.T) {
+	assertT := assert.New(t)
+
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().WithHotKeys(5, 4*time.Second).WithClock(clock)
+	defer em.Discard()
+
+	_, _ = em.Get("a")
+	assertT.Equal(uint64(1), em.Frequency("a"))
+	clock.Advance(5 * time.Second)
+	assertT.Equal(uint64(0), em.Frequency("a"))
+	assertT.Empty(em.TopKeys(5))
+}
+
+func TestHotKeyEvent(t *testing.T) {
+	assertT := assert.New(t)
+
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().WithClock(clock).WithHotKeyRate(1).WithHotKeys(5, 4*time.Second)
+	defer em.Discard()
+	_ = em.Put("a", 1)
+	events, cancel := em.Subscribe(10, HotKey)
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		_, _ = em.Get("a")
+		_, _ = em.Get("b")
+	}
+	assertT.Equal(2, len(events)) // raised once per key on crossing 4 requests per window
+	ev := <-events
+	assertT.Equal("a", ev.Key)
+	assertT.Equal(1, ev.Value)
+	ev = <-events
+	assertT.Equal("b", ev.Key)
+	assertT.Equal(0, ev.Value)
+
+	clock.Advance(time.Hour) // cooled down
+	for i := 0; i < 4; i++ {
+		_, _ = em.Get("a")
+	}
+	assertT.Equal(1, len(events))
+}
+
+func TestShardedTopKeys(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](4).WithHotKeys(2, time.Minute)
+	defer sm.Discard()
+	for i := 0; i < 8; i++ {
+		key := "k" + strconv.Itoa(i)
+		for j := 0; j <= i; j++ {
+			_, _ = sm.Get(key)
+		}
+	}
+
+	top := sm.TopKeys(2)
+	assertT.Equal(2, len(top))
+	assertT.Equal("k7", top[0].Key)
+	assertT.Equal("k6", top[1].Key)
+	assertT.GreaterOrEqual(sm.Frequency("k7"), uint64(8))
+}
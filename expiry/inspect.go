@@ -0,0 +1,99 @@
+package expiry
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metadata of a map entry
+type EntryInfo struct {
+	// time the entry was added, loaded, refreshed or unpinned
+	LoadedAt time.Time
+	// time of the last `Get` or `Peek` that found the entry; zero if the entry wasn't accessed
+	LastAccess time.Time
+	// number of `Get` and `Peek` calls that found the entry
+	Accesses uint64
+	// time left until the entry expires, `Eternity` if it doesn't expire
+	RemainingTTL time.Duration
+	// share of map capacity taken by the entry - 1, or 0 for a pinned entry that doesn't count against capacity
+	Weight int
+	// time taken by the loader to produce the value, zero if the value was put directly
+	LoadDuration time.Duration
+	// whether the entry is pinned
+	Pinned bool
+	// tags returned by the loader
+	Tags []string
+}
+
+// Access counters of an entry. Shared by copies of the entry, so that they can be updated with R-lock.
+type accessStats struct {
+	count atomic.Uint64
+	last  atomic.Int64 // Unix time in nanoseconds
+}
+
+func (as *accessStats) record(now time.Time) {
+	as.count.Add(1)
+	as.last.Store(now.UnixNano())
+}
+
+// Makes events about an entry carry its metadata in `Event.Info`, which is delivered to subscribers only.
+func (em *ExpiryMap[K, V]) WithEntryInfoInEvents(enabled bool) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.eventInfo = enabled
+	})
+	return em
+}
+
+// Returns metadata of the entry. Neither loader nor listeners are invoked, and the call doesn't count as an access.
+//   - the second value is `false` if there is no mapping for the key
+func (em *ExpiryMap[K, V]) Inspect(key K) (EntryInfo, bool) {
+	var info EntryInfo
+	var ok bool
+	em.ReadAtomically(func() {
+		var ent entry[V]
+		if ent, ok = em.getEntry(key); ok {
+			info = em.entryInfo(ent)
+		}
+	})
+	return info, ok
+}
+
+// Returns metadata of the entry. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) entryInfo(ent entry[V]) EntryInfo {
+	info := EntryInfo{
+		LoadedAt:     ent.created,
+		Accesses:     ent.access.count.Load(),
+		RemainingTTL: ent.remainingTTL(),
+		Weight:       1,
+		LoadDuration: ent.loadTime,
+		Pinned:       ent.pinned,
+		Tags:         append([]string{}, ent.tags...),
+	}
+	if last := ent.access.last.Load(); last != 0 {
+		info.LastAccess = time.Unix(0, last)
+	}
+	if ent.pinned && !em.pinnedCounted {
+		info.Weight = 0
+	}
+	return info
+}
+
+// Returns time left until the entry expires, `Eternity` if it doesn't expire
+func (ent entry[V]) remainingTTL() time.Duration {
+	if ent.expires.IsZero() {
+		return Eternity
+	}
+	if left := time.Until(ent.expires); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// Queues event about the entry, attaching its metadata if enabled
+func (em *ExpiryMap[K, V]) postEntryEvent(e Event[K, V], ent entry[V]) {
+	if em.eventInfo {
+		info := em.entryInfo(ent)
+		e.Info = &info
+	}
+	em.dispatcher.post(e)
+}
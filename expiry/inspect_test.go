@@ -0,0 +1,115 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		ExpireAfter(time.Minute).
+		WithTaggedLoader(func(key string) (int, []string, error) {
+			time.Sleep(sleepTime)
+			return len(key), []string{"t"}, nil
+		})
+	defer em.Discard()
+	events, cancel := em.Subscribe(10)
+	defer cancel()
+
+	_, ok := em.Inspect("abc")
+	assertT.False(ok)
+
+	before := time.Now()
+	_, _ = em.Get("abc")
+	info, ok := em.Inspect("abc")
+	assertT.True(ok)
+	assertT.Equal(uint64(0), info.Accesses)
+	assertT.True(info.LastAccess.IsZero())
+	assertT.GreaterOrEqual(info.LoadDuration, sleepTime)
+	assertT.False(info.LoadedAt.Before(before))
+	assertT.Greater(info.RemainingTTL, time.Minute-waitTime)
+	assertT.Equal(1, info.Weight)
+	assertT.Equal([]string{"t"}, info.Tags)
+
+	_, _ = em.Get("abc")
+	_, _ = em.Peek("abc")
+	info, _ = em.Inspect("abc")
+	assertT.Equal(uint64(2), info.Accesses)
+	assertT.False(info.LastAccess.Before(info.LoadedAt))
+
+	assertT.Equal(3, len(events)) // inspection raises no events
+	assertT.Equal(uint64(2), em.Stats().Hits)
+}
+
+func TestInspectPinnedAndPut(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().ExpireAfter(time.Minute)
+	defer em.Discard()
+	_ = em.Put("a", 1)
+	_ = em.PutPinned("b", 2)
+
+	info, _ := em.Inspect("a")
+	assertT.Equal(time.Duration(0), info.LoadDuration)
+	assertT.Empty(info.Tags)
+	info, _ = em.Inspect("b")
+	assertT.True(info.Pinned)
+	assertT.Equal(0, info.Weight)
+	assertT.Equal(time.Duration(Eternity), info.RemainingTTL)
+
+	em.WithPinnedCounted(true)
+	info, _ = em.Inspect("b")
+	assertT.Equal(1, info.Weight)
+}
+
+func TestInspectKeepsAccessesOnRefresh(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(time.Millisecond)
+			return len(key), nil
+		}).
+		ExpireAfter(time.Hour).
+		WithEarlyRefresh(1e9). // any load time outweighs the hour left
+		WithEntryInfoInEvents(true)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Refreshed)
+	defer cancel()
+
+	_, _ = em.Get("abc")
+	_, _ = em.Get("abc") // triggers refresh
+
+	ev := <-events
+	assertT.Equal(uint64(1), ev.Info.Accesses)
+	info, _ := em.Inspect("abc")
+	assertT.Equal(uint64(1), info.Accesses)
+}
+
+func TestEntryInfoInEvents(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]()
+	defer em.Discard()
+	events, cancel := em.Subscribe(10)
+	defer cancel()
+
+	_ = em.Put("a", 1)
+	assertT.Nil((<-events).Info)
+
+	em.WithEntryInfoInEvents(true)
+	_, _ = em.Get("a")
+	em.Remove("a")
+	ev := <-events
+	assertT.Equal(Requested, ev.Type)
+	assertT.NotNil(ev.Info)
+	assertT.Equal(uint64(1), ev.Info.Accesses)
+	ev = <-events
+	assertT.Equal(Removed, ev.Type)
+	assertT.NotNil(ev.Info)
+	assertT.Equal(uint64(1), ev.Info.Accesses)
+}
@@ -0,0 +1,135 @@
+package expiry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// Message that tells map instances to drop a key or all their entries
+type Invalidation[K comparable] struct {
+	// identifier of the publishing map instance; instances ignore their own messages
+	Origin string
+	// invalidated key; ignored if `All` is set
+	Key K
+	// all entries are invalidated
+	All bool
+}
+
+// Transport of invalidation messages between map instances, e.g. replicas of a service
+type Invalidator[K comparable] interface {
+	// Sends the message to all subscribers, including those of the publishing process
+	Publish(msg Invalidation[K]) error
+	// Registers handler of incoming messages. Returns a function that cancels the subscription.
+	Subscribe(handler func(msg Invalidation[K])) func()
+}
+
+// In-process invalidator that delivers messages synchronously to all subscribers. Useful in tests and for maps within one process.
+type Bus[K comparable] struct {
+	lock     sync.Mutex
+	handlers map[int]func(msg Invalidation[K])
+	nextID   int
+}
+
+// Creates bus without subscribers
+func NewBus[K comparable]() *Bus[K] {
+	return &Bus[K]{handlers: make(map[int]func(msg Invalidation[K]))}
+}
+
+// Delivers the message to all subscribers. Never fails.
+func (bus *Bus[K]) Publish(msg Invalidation[K]) error {
+	for _, handler := range bus.snapshot() {
+		handler(msg)
+	}
+	return nil
+}
+
+// Registers handler of messages
+func (bus *Bus[K]) Subscribe(handler func(msg Invalidation[K])) func() {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	id := bus.nextID
+	bus.nextID++
+	bus.handlers[id] = handler
+	return func() {
+		bus.lock.Lock()
+		defer bus.lock.Unlock()
+		delete(bus.handlers, id)
+	}
+}
+
+func (bus *Bus[K]) snapshot() []func(msg Invalidation[K]) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	handlers := make([]func(msg Invalidation[K]), 0, len(bus.handlers))
+	for _, handler := range bus.handlers {
+		handlers = append(handlers, handler)
+	}
+	return handlers
+}
+
+func newInstanceID() string {
+	var buf [8]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// Attaches the map to the invalidator. After changing the map `Remove`, successful `Replace` and `Clear` publish
+// invalidation messages, while messages of other instances remove the key or all entries locally without publishing them again.
+// Remotely invalidated entries are reported with `Removed` events of `CauseRemote` and are not propagated to the writer.
+// Failure to publish is reported with `InvalidationFailed` event. `nil` detaches the map.
+func (em *ExpiryMap[K, V]) WithInvalidator(inv Invalidator[K]) *ExpiryMap[K, V] {
+	em.attachInvalidator(inv, newInstanceID())
+	if inv != nil {
+		em.unsubscribeInv = inv.Subscribe(em.applyInvalidation)
+	}
+	return em
+}
+
+// Sets invalidator used for publishing without subscribing to it
+func (em *ExpiryMap[K, V]) attachInvalidator(inv Invalidator[K], instanceID string) {
+	em.detachInvalidator()
+	em.invalidator = inv
+	em.instanceID = instanceID
+}
+
+func (em *ExpiryMap[K, V]) detachInvalidator() {
+	if em.unsubscribeInv != nil {
+		em.unsubscribeInv()
+		em.unsubscribeInv = nil
+	}
+	em.invalidator = nil
+}
+
+// Publishes invalidation of the key or of all entries. Must be called without lock.
+func (em *ExpiryMap[K, V]) publishInvalidation(key K, all bool) {
+	if em.invalidator == nil {
+		return
+	}
+	if err := em.invalidator.Publish(Invalidation[K]{Origin: em.instanceID, Key: key, All: all}); err != nil {
+		var zero V
+		em.notifyListeners(InvalidationFailed, key, zero, err)
+	}
+}
+
+// Applies invalidation message of another instance
+func (em *ExpiryMap[K, V]) applyInvalidation(msg Invalidation[K]) {
+	if msg.Origin == em.instanceID || em.IsClosed() {
+		return
+	}
+	var invalidated func(key K, all bool)
+	em.WriteAtomically(func() {
+		if msg.All {
+			em.removeAll(CauseRemote)
+			em.stale.clear()
+		} else {
+			em.removeEntry(msg.Key, CauseRemote)
+			em.stale.remove(msg.Key)
+		}
+		invalidated = em.invalidated
+	})
+	if invalidated != nil {
+		invalidated(msg.Key, msg.All)
+	}
+	em.flushEvents()
+}
@@ -0,0 +1,178 @@
+package expiry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newReplicas(inv Invalidator[string], count int) []*ExpiryMap[string, int] {
+	replicas := make([]*ExpiryMap[string, int], count)
+	for i := range replicas {
+		replicas[i] = NewExpiryMap[string, int]().
+			WithLoader(func(key string) (int, error) { return len(key), nil }).
+			WithInvalidator(inv)
+		_, _ = replicas[i].Get("Hi")
+		_, _ = replicas[i].Get("Hello")
+	}
+	return replicas
+}
+
+func discardAll(replicas []*ExpiryMap[string, int]) {
+	for _, em := range replicas {
+		em.Discard()
+	}
+}
+
+func TestBus(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	var got1, got2 []Invalidation[string]
+	cancel1 := bus.Subscribe(func(msg Invalidation[string]) { got1 = append(got1, msg) })
+	bus.Subscribe(func(msg Invalidation[string]) { got2 = append(got2, msg) })
+
+	msg := Invalidation[string]{Origin: "a", Key: "Hi"}
+	assertT.Nil(bus.Publish(msg))
+	cancel1()
+	assertT.Nil(bus.Publish(Invalidation[string]{Origin: "a", All: true}))
+
+	assertT.Equal([]Invalidation[string]{msg}, got1)
+	assertT.Len(got2, 2)
+}
+
+func TestInvalidateRemove(t *testing.T) {
+	assertT := assert.New(t)
+
+	replicas := newReplicas(NewBus[string](), 3)
+	defer discardAll(replicas)
+	events, cancel := replicas[1].Subscribe(10, Removed)
+	defer cancel()
+
+	assertT.True(replicas[0].Remove("Hi"))
+	for _, em := range replicas {
+		assertT.False(em.ContainsKey("Hi"))
+		assertT.True(em.ContainsKey("Hello"))
+	}
+	ev := <-events
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(CauseRemote, ev.Cause)
+	assertT.Equal(uint64(1), replicas[1].Stats().Removals)
+
+	// absent key is broadcast as well
+	_, _ = replicas[2].Get("World")
+	assertT.False(replicas[0].Remove("World"))
+	assertT.False(replicas[2].ContainsKey("World"))
+}
+
+func TestInvalidateReplaceAndClear(t *testing.T) {
+	assertT := assert.New(t)
+
+	replicas := newReplicas(NewBus[string](), 3)
+	defer discardAll(replicas)
+
+	assertT.True(replicas[0].Replace("Hi", 7))
+	v, _ := replicas[0].Peek("Hi")
+	assertT.Equal(7, v)
+	assertT.False(replicas[1].ContainsKey("Hi"))
+	assertT.False(replicas[2].ContainsKey("Hi"))
+	v, _ = replicas[1].Get("Hi")
+	assertT.Equal(2, v)
+
+	assertT.False(replicas[2].Replace("World", 1))
+	assertT.Equal(2, replicas[1].Len())
+
+	replicas[2].Clear()
+	for _, em := range replicas {
+		assertT.Equal(0, em.Len())
+	}
+}
+
+func TestInvalidateNoWriteBack(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	writer1, writer2 := newRecordingWriter(), newRecordingWriter()
+	em1 := NewExpiryMap[string, int]().WithWriteThrough(writer1).WithInvalidator(bus)
+	defer em1.Discard()
+	em2 := NewExpiryMap[string, int]().WithWriteThrough(writer2).WithInvalidator(bus)
+	defer em2.Discard()
+	_ = em1.Put("Hi", 2)
+	_ = em2.Put("Hi", 2)
+
+	em1.Remove("Hi")
+	assertT.False(em2.ContainsKey("Hi"))
+	ops1, _ := writer1.snapshot()
+	ops2, _ := writer2.snapshot()
+	assertT.Equal([]string{"W:Hi", "D:Hi"}, ops1)
+	assertT.Equal([]string{"W:Hi"}, ops2)
+}
+
+type failingInvalidator struct {
+	*Bus[string]
+}
+
+func (fi failingInvalidator) Publish(msg Invalidation[string]) error {
+	return errors.New("network is down")
+}
+
+func TestInvalidationFailed(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().WithInvalidator(failingInvalidator{NewBus[string]()})
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, InvalidationFailed)
+	defer cancel()
+	_ = em.Put("Hi", 2)
+
+	assertT.True(em.Remove("Hi"))
+	ev := <-events
+	assertT.Equal("Hi", ev.Key)
+	assertT.EqualError(ev.Err, "network is down")
+}
+
+func TestDetachInvalidator(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	replicas := newReplicas(bus, 2)
+	defer discardAll(replicas)
+
+	replicas[1].WithInvalidator(nil)
+	replicas[0].Remove("Hi")
+	assertT.True(replicas[1].ContainsKey("Hi"))
+
+	replicas[0].Close()
+	assertT.Empty(bus.snapshot())
+}
+
+func TestShardedInvalidation(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	var published []Invalidation[string]
+	bus.Subscribe(func(msg Invalidation[string]) { published = append(published, msg) })
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithInvalidator(bus)
+	defer sm.Discard()
+	replicas := newReplicas(bus, 1)
+	defer discardAll(replicas)
+	_, _ = sm.Get("Hi")
+	_, _ = sm.Get("Hello")
+
+	replicas[0].Remove("Hi")
+	assertT.False(sm.ContainsKey("Hi"))
+	assertT.True(sm.ContainsKey("Hello"))
+
+	published = nil
+	sm.Clear()
+	assertT.Len(published, 1)
+	assertT.True(published[0].All)
+	assertT.Equal(0, replicas[0].Len())
+
+	_, _ = replicas[0].Get("Hi")
+	sm.Remove("Hi")
+	assertT.False(replicas[0].ContainsKey("Hi"))
+}
@@ -2,7 +2,10 @@
 package expiry
 
 import (
+	"context"
 	"errors"
+	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/aknopov/handymaps/internal/util"
@@ -16,19 +19,69 @@ const (
 )
 
 type entry[V any] struct {
-	val    V
-	exptmr *time.Timer
+	val      V
+	exptmr   *time.Timer   // `nil` in passive mode
+	created  time.Time     // time the entry was added, refreshed or unpinned
+	pinned   bool          // exempt from expiry and eviction
+	vetoes   int           // number of vetoed expirations
+	tags     []string      // tags returned by the loader
+	access   *accessStats  // `Get` and `Peek` hits, shared by copies of the entry
+	expires  time.Time     // zero if the entry doesn't expire
+	loadTime time.Duration // time taken by the loader, zero if the value was put directly
+	gen      uint64        // distinguishes timers of an entry re-added under the same key
+}
+
+// Expiry timer notification
+type expiration[K comparable] struct {
+	key K
+	gen uint64
 }
 
 // Implementation of a map which entries expire after certain time.
 type ExpiryMap[K comparable, V any] struct {
-	backMap     ordered.OrderedMap[K, entry[V]]
-	maxCapacity int
-	ttl         time.Duration
-	loader      func(key K) (V, error)
-	listeners   *util.Set[Listener[K, V]]
-	evictChan   chan K
-	stopChan    chan bool
+	backMap        ordered.OrderedMap[K, entry[V]]
+	maxCapacity    int
+	ttl            time.Duration
+	loader         func(key K) (V, error)
+	taggedLoader   func(key K) (V, []string, error) // `nil` unless set with `WithTaggedLoader`
+	tags           tagIndex[K]
+	dispatcher     *dispatcher[K, V]
+	subOverflow    OverflowPolicy
+	evictChan      chan expiration[K]
+	nextGen        uint64
+	stopChan       chan struct{}
+	closed         atomic.Bool
+	stats          statsCounters
+	writer         Writer[K, V]
+	behind         *writeBehind[K, V]
+	maxStale       time.Duration
+	stale          *staleArea[K, V]
+	retry          RetryPolicy
+	attemptTimeout time.Duration
+	breaker        *circuitBreaker
+	clock          Clock
+	tracer         Tracer
+	invalidator    Invalidator[K]
+	instanceID     string
+	unsubscribeInv func()
+	ttlJitter      float64
+	refreshBeta    float64
+	refreshing     refreshSet[K]
+	loading        loadGroup[K, V]
+	preloadLimit   int
+	warm           *warmup
+	passive        bool              // expiry is enforced lazily without timers
+	reentrant      bool              // whether the loader may call back into the map, set by `MemoizeMap`
+	janitor        *Janitor          // `nil` if not registered with a janitor
+	pinnedCounted  bool              // whether pinned entries count against capacity
+	eventInfo      bool              // whether events carry entry metadata
+	hotKeys        *hotKeyTracker[K] // `nil` unless enabled with `WithHotKeys`
+	hotKeyRate     float64           // requests per second that make a key hot, 0 if disabled
+	beforeEvict    func(key K, val V, cause Cause) bool
+	demote         func(key K, val V, tags []string) // called with W-lock for entries evicted to ensure capacity, set by `Tiered`
+	invalidated    func(key K, all bool)             // called without lock after a remote invalidation is applied, set by `Tiered`
+	maxVetoes      int
+	vetoDelay      time.Duration
 	util.UpgradableRWMutex
 }
 
@@ -36,7 +89,7 @@ type EventType int
 
 // ExpiryMap events
 const (
-	// added by loader
+	// added by loader or `Put`
 	Added EventType = iota
 	// expired and removed
 	Expired
@@ -50,8 +103,64 @@ const (
 	Replaced
 	// failed load
 	Failed
+	// failed write to the map's `Writer`
+	WriteFailed
+	// stale value returned after failed load
+	ServedStale
+	// circuit breaker opened after failed loads
+	BreakerOpened
+	// circuit breaker allowed a probe load
+	BreakerHalfOpened
+	// circuit breaker closed after successful probe load
+	BreakerClosed
+	// failed publishing of invalidation message
+	InvalidationFailed
+	// reloaded before expiry
+	Refreshed
+	// warm-up completed, successfully or not
+	WarmedUp
+	// eviction or expiry vetoed by `BeforeEvict` hook
+	Vetoed
+	// request rate of the key reached the rate set with `WithHotKeyRate`
+	HotKey
+)
+
+// Reason of entry removal
+type Cause int
+
+const (
+	// event is not a removal
+	CauseNone Cause = iota
+	// time-to-live of the entry has elapsed
+	CauseExpired
+	// removed to ensure capacity
+	CauseCapacity
+	// removed with `Remove`, `Clear` or `Discard`
+	CauseExplicit
+	// removed by invalidation message of another instance
+	CauseRemote
 )
 
+// ExpiryMap event as seen by subscribers
+type Event[K comparable, V any] struct {
+	// event type
+	Type EventType
+	// key assiosciated with the event
+	Key K
+	// the associated value
+	Value V
+	// optional error on failure
+	Err error
+	// reason of removal for `Expired` and `Removed` events, or of vetoed removal for `Vetoed` event
+	Cause Cause
+	// time when the event occurred
+	Time time.Time
+	// time taken by the loader for `Added`, `Refreshed` and `Failed` events caused by loading, or by the warm-up for `WarmedUp` event, zero otherwise
+	Duration time.Duration
+	// metadata of the entry the event is about, if enabled with `WithEntryInfoInEvents`; `nil` otherwise
+	Info *EntryInfo
+}
+
 // Listener interface to ExpiryMap events
 type Listener[K comparable, V any] interface {
 	// Function to receive on each ExpiryMap event
@@ -73,66 +182,223 @@ func (lw *ListenerWarapper) Listen(ev EventType, key string, val int, err error)
 
 // Creates ExpiryMap with default field values - unlimited capacity without entries expiry
 func NewExpiryMap[K comparable, V any]() *ExpiryMap[K, V] {
-	var deflt V
-	ret := ExpiryMap[K, V]{
-		backMap:     *ordered.NewOrderedMap[K, entry[V]](),
-		maxCapacity: Unlimited,
-		ttl:         Eternity,
-		loader:      func(key K) (V, error) { return deflt, errors.New("loader not defined") },
-		listeners:   util.NewSet[Listener[K, V]](),
-		evictChan:   make(chan K),
-		stopChan:    make(chan bool),
-	}
+	return NewExpiryMapWithContext[K, V](context.Background())
+}
+
+// Creates ExpiryMap like `NewExpiryMap` that gets closed when the context is cancelled
+func NewExpiryMapWithContext[K comparable, V any](ctx context.Context) *ExpiryMap[K, V] {
+	ret := newExpiryMap[K, V]()
 
 	go func() {
 		for {
 			select {
-			case key := <-ret.evictChan:
+			case exp := <-ret.evictChan:
 				ret.WriteAtomically(func() {
-					ret.removeEntry(key)
+					if ent, ok := ret.backMap.Get(exp.key); ok && ent.gen == exp.gen {
+						ret.expire(context.Background(), exp.key)
+					}
 				})
+				ret.flushEvents()
 			case <-ret.stopChan:
 				return
+			case <-ctx.Done():
+				_ = ret.Close()
+				return
 			}
 		}
 	}()
 
-	return &ret
+	return ret
+}
+
+// Creates ExpiryMap like `NewExpiryMap` that enforces expiry lazily, without timers and background goroutine.
+// Expired entries are treated as absent on access and are purged incrementally by operations that change the map,
+// by `Cleanup` or by a shared `Janitor`. Forgetting to close such a map leaks nothing.
+func NewPassiveExpiryMap[K comparable, V any]() *ExpiryMap[K, V] {
+	ret := newExpiryMap[K, V]()
+	ret.passive = true
+	return ret
+}
+
+func newExpiryMap[K comparable, V any]() *ExpiryMap[K, V] {
+	var deflt V
+	return &ExpiryMap[K, V]{
+		backMap:      *ordered.NewOrderedMap[K, entry[V]](),
+		maxCapacity:  Unlimited,
+		ttl:          Eternity,
+		loader:       func(key K) (V, error) { return deflt, errors.New("loader not defined") },
+		dispatcher:   newDispatcher[K, V](),
+		subOverflow:  DropOldest,
+		stale:        newStaleArea[K, V](),
+		clock:        systemClock{},
+		tracer:       NoopTracer{},
+		refreshing:   refreshSet[K]{keys: make(map[K]struct{})},
+		loading:      loadGroup[K, V]{loads: make(map[K]*sharedLoad[V])},
+		tags:         tagIndex[K]{keys: make(map[string]map[K]struct{})},
+		preloadLimit: runtime.GOMAXPROCS(0),
+		maxVetoes:    defaultMaxVetoes,
+		vetoDelay:    defaultVetoDelay,
+		evictChan:    make(chan expiration[K]),
+		stopChan:     make(chan struct{}),
+	}
 }
 
 // Modifies max capacity of the map. If adding new entry exceeds map capacity, the oldest entry is evicted.
 func (em *ExpiryMap[K, V]) WithMaxCapacity(maxCapacity int) *ExpiryMap[K, V] {
-	em.maxCapacity = maxCapacity
+	em.SetMaxCapacity(maxCapacity)
 	return em
 }
 
 // Modifes map entries time-to-live period
 func (em *ExpiryMap[K, V]) ExpireAfter(ttl time.Duration) *ExpiryMap[K, V] {
-	em.ttl = ttl
+	em.SetTTL(ttl, false)
 	return em
 }
 
+// Changes max capacity of a live map. If the map holds more entries than the new capacity, the oldest ones are evicted
+// immediately with `Removed` events of `CauseCapacity`; expired entries of a passive map go first.
+func (em *ExpiryMap[K, V]) SetMaxCapacity(maxCapacity int) {
+	em.WriteAtomically(func() {
+		em.maxCapacity = maxCapacity
+		if em.maxCapacity != Unlimited && em.backMap.Len() > em.maxCapacity {
+			em.purgeAllExpired()
+			em.ensureCapacity(context.Background(), em.maxCapacity)
+		}
+	})
+	em.flushEvents()
+}
+
+// Changes time-to-live period of a live map.
+//   - applyToExisting - if `false`, the period applies to entries added afterwards. Otherwise expiry time of present unpinned entries
+//     is rescheduled to the new period after they were added or refreshed, so that overdue entries expire at once.
+//     Jitter, if configured, is applied anew.
+func (em *ExpiryMap[K, V]) SetTTL(ttl time.Duration, applyToExisting bool) {
+	em.WriteAtomically(func() {
+		em.ttl = ttl
+		if !applyToExisting {
+			return
+		}
+		it := em.backMap.Iterator()
+		for it.HasNext() {
+			if key, ent := it.Next(); !ent.pinned {
+				em.schedule(key, &ent, em.entryTTL())
+				em.backMap.Put(key, ent)
+			}
+		}
+	})
+}
+
 // Modifes map's loader that provides values for a new  key
 func (em *ExpiryMap[K, V]) WithLoader(loader func(key K) (V, error)) *ExpiryMap[K, V] {
 	em.loader = loader
+	em.taggedLoader = nil
 	return em
 }
 
+// Switches delivery of events to a dedicated goroutine, so that slow listeners do not hold up map operations.
+//   - bufSize - size of the queue of undelivered events
+//   - overflow - policy applied when the queue is full
+func (em *ExpiryMap[K, V]) WithAsyncListeners(bufSize int, overflow OverflowPolicy) *ExpiryMap[K, V] {
+	em.dispatcher.startAsync(bufSize, overflow)
+	return em
+}
+
+// Restores the default synchronous delivery of events after the map lock is released.
+func (em *ExpiryMap[K, V]) WithSyncListeners() *ExpiryMap[K, V] {
+	em.dispatcher.stop()
+	return em
+}
+
+// Modifies policy applied to subscribers that don't keep up with events. Affects subscriptions made afterwards.
+func (em *ExpiryMap[K, V]) WithSubscriptionOverflow(overflow OverflowPolicy) *ExpiryMap[K, V] {
+	em.subOverflow = overflow
+	return em
+}
+
+// Makes the map retain expired values for up to `maxStale` period. If reloading of such a value fails, `Get` returns
+// the stale value with an error that wraps both `ErrStale` and the loader error. Zero period disables the mode.
+func (em *ExpiryMap[K, V]) StaleIfError(maxStale time.Duration) *ExpiryMap[K, V] {
+	em.maxStale = maxStale
+	if maxStale <= 0 {
+		em.stale.clear()
+	}
+	return em
+}
+
+// Makes `Put`, `Replace` and `Remove` write synchronously to the writer before changing the map.
+// If writing fails, the map is not changed.
+func (em *ExpiryMap[K, V]) WithWriteThrough(writer Writer[K, V]) *ExpiryMap[K, V] {
+	em.stopWriteBehind()
+	em.WriteAtomically(func() {
+		em.writer = writer
+	})
+	return em
+}
+
+// Makes `Put`, `Replace` and `Remove` queue writes that are flushed to the writer on a background goroutine.
+// Writes for the same key are coalesced, and `Close` flushes the queue.
+//   - interval - period of flushing the queue
+//   - batchSize - max number of writes per flush; the queue is flushed early when it reaches this size. Zero means no limit
+//   - maxRetries - number of retries of a failed write on subsequent flushes before `WriteFailed` event is raised
+func (em *ExpiryMap[K, V]) WithWriteBehind(writer Writer[K, V], interval time.Duration, batchSize int, maxRetries int) *ExpiryMap[K, V] {
+	em.stopWriteBehind()
+	behind := newWriteBehind(writer, interval, batchSize, maxRetries, func(key K, val V, err error) {
+		em.notifyListeners(WriteFailed, key, val, err)
+		em.flushEvents()
+	})
+	em.WriteAtomically(func() {
+		em.writer = writer
+		em.behind = behind
+	})
+	return em
+}
+
+// Detaches write-behind queue under the lock and flushes it without the lock
+func (em *ExpiryMap[K, V]) stopWriteBehind() {
+	var behind *writeBehind[K, V]
+	em.WriteAtomically(func() {
+		behind = em.behind
+		em.behind = nil
+	})
+	if behind != nil {
+		behind.stop()
+	}
+}
+
 // Returns map capacity
 func (em *ExpiryMap[K, V]) Capacity() int {
-	return em.maxCapacity
+	var maxCapacity int
+	em.ReadAtomically(func() {
+		maxCapacity = em.maxCapacity
+	})
+	return maxCapacity
 }
 
 // Returns expiry period
 func (em *ExpiryMap[K, V]) ExpireTime() time.Duration {
-	return em.ttl
+	var ttl time.Duration
+	em.ReadAtomically(func() {
+		ttl = em.ttl
+	})
+	return ttl
+}
+
+// Returns cumulative statistics of map operations
+func (em *ExpiryMap[K, V]) Stats() Stats {
+	stats := em.stats.snapshot()
+	stats.DroppedEvents = em.dispatcher.dropped.Load()
+	return stats
 }
 
 // Returns length of the map
 func (em *ExpiryMap[K, V]) Len() int {
 	var size int
 	em.ReadAtomically(func() {
-		size = em.backMap.Len()
+		if em.passive {
+			size = len(em.liveKeys())
+		} else {
+			size = em.backMap.Len()
+		}
 	})
 	return size
 }
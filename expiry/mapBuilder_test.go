@@ -25,7 +25,7 @@ func TestCreation(t *testing.T) {
 	assertT.Equal(maxCapacity, em.Capacity())
 	assertT.Equal(ttl, em.ExpireTime())
 	assertT.NotNil(em.loader)
-	assertT.Equal(0, em.listeners.Size())
+	assertT.Equal(0, em.dispatcher.listeners.Size())
 }
 
 func TestLoader(t *testing.T) {
@@ -48,3 +48,83 @@ func listener1(ev EventType, key string, val int, err error) {
 func listener2(ev EventType, key string, val int, err error) {
 	fmt.Printf("2: Received event: %v, key=%v, val=%v, err=%v\n", ev, key, val, err)
 }
+
+func TestSetMaxCapacity(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]()
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Removed)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		_ = em.Put(i, i)
+	}
+
+	em.SetMaxCapacity(2)
+	assertT.Equal(2, em.Capacity())
+	assertT.Equal([]int{3, 4}, em.Keys())
+	assertT.Equal(3, len(events))
+	ev := <-events
+	assertT.Equal(0, ev.Key)
+	assertT.Equal(CauseCapacity, ev.Cause)
+
+	em.SetMaxCapacity(Unlimited)
+	_ = em.Put(5, 5)
+	assertT.Equal(3, em.Len())
+}
+
+func TestSetMaxCapacityPassive(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[int, int]()
+	defer em.Discard()
+	_ = em.Put(0, 0)
+	em.SetTTL(sleepTime, false)
+	_ = em.Put(1, 1)
+	_ = em.Put(2, 2)
+	time.Sleep(2 * sleepTime)
+
+	em.SetMaxCapacity(1) // expired entries go first
+	assertT.Equal([]int{0}, em.Keys())
+	assertT.Equal(uint64(2), em.Stats().Expirations)
+	assertT.Equal(uint64(0), em.Stats().Evictions)
+}
+
+func TestSetTTL(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().ExpireAfter(time.Hour)
+	defer em.Discard()
+	_ = em.Put("Hi", 1)
+
+	em.SetTTL(time.Minute, false)
+	assertT.Equal(time.Minute, em.ExpireTime())
+	left, _ := em.RemainingTTL("Hi")
+	assertT.Greater(left, time.Minute)
+
+	_ = em.Put("Hello", 2)
+	left, _ = em.RemainingTTL("Hello")
+	assertT.LessOrEqual(left, time.Minute)
+
+	em.SetTTL(Eternity, true)
+	left, _ = em.RemainingTTL("Hi")
+	assertT.Equal(time.Duration(Eternity), left)
+
+	em.SetTTL(sleepTime, true)
+	left, _ = em.RemainingTTL("Hi")
+	assertT.LessOrEqual(left, sleepTime)
+	assertT.Eventually(func() bool { return em.Len() == 0 }, waitTime, sleepTime)
+	assertT.Equal(uint64(2), em.Stats().Expirations)
+}
+
+func TestSetTTLOverdue(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().ExpireAfter(time.Hour)
+	defer em.Discard()
+	_ = em.Put("Hi", 1)
+	time.Sleep(2 * sleepTime)
+
+	em.SetTTL(sleepTime, true)
+	assertT.Eventually(func() bool { return em.Len() == 0 }, sleepTime, sleepTime/10)
+}
@@ -0,0 +1,49 @@
+package expiry
+
+// Configures a map with builder methods, e.g.
+//
+//	func(em *ExpiryMap[string, int]) *ExpiryMap[string, int] { return em.ExpireAfter(time.Minute).WithMaxCapacity(100) }
+type Option[K comparable, V any] func(em *ExpiryMap[K, V]) *ExpiryMap[K, V]
+
+// Key of a function of two arguments
+type Pair[A comparable, B comparable] struct {
+	First  A
+	Second B
+}
+
+// Wraps the function with a map that caches its results - the map loads values with the function.
+// Errors are not cached. Options are applied in order after the loader is set, hence they must not replace it.
+// Without options the map is unbounded and results never expire - use `WithMaxCapacity` and `ExpireAfter` to limit it.
+// The map is passive, so the wrapper leaks nothing when dropped, unless options start goroutines, e.g. `WithWriteBehind`.
+// The function runs without holding the map lock, so it may call the memoized function recursively for other keys.
+// Functions of several arguments can be memoized with a struct key, or with `Memoize2`.
+func Memoize[K comparable, V any](fn func(K) (V, error), opts ...Option[K, V]) func(K) (V, error) {
+	memoized, _ := MemoizeMap(fn, opts...)
+	return memoized
+}
+
+// Same as `Memoize`, but also returns the map for invalidation, statistics and cleanup - see `NewPassiveExpiryMap`.
+func MemoizeMap[K comparable, V any](fn func(K) (V, error), opts ...Option[K, V]) (func(K) (V, error), *ExpiryMap[K, V]) {
+	em := NewPassiveExpiryMap[K, V]().WithLoader(fn)
+	em.reentrant = true
+	for _, opt := range opts {
+		em = opt(em)
+	}
+	return em.Get, em
+}
+
+// Wraps the function of two arguments like `Memoize`. Results are cached by `Pair` of the arguments.
+func Memoize2[A comparable, B comparable, V any](fn func(A, B) (V, error), opts ...Option[Pair[A, B], V]) func(A, B) (V, error) {
+	memoized, _ := Memoize2Map(fn, opts...)
+	return memoized
+}
+
+// Same as `Memoize2`, but also returns the map.
+func Memoize2Map[A comparable, B comparable, V any](fn func(A, B) (V, error), opts ...Option[Pair[A, B], V]) (func(A, B) (V, error), *ExpiryMap[Pair[A, B], V]) {
+	memoized, em := MemoizeMap(func(key Pair[A, B]) (V, error) {
+		return fn(key.First, key.Second)
+	}, opts...)
+	return func(a A, b B) (V, error) {
+		return memoized(Pair[A, B]{a, b})
+	}, em
+}
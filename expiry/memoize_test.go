@@ -0,0 +1,175 @@
+package expiry
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoize(t *testing.T) {
+	assertT := assert.New(t)
+
+	calls := 0
+	upper := Memoize(func(s string) (string, error) {
+		calls++
+		return strings.ToUpper(s), nil
+	})
+
+	v, err := upper("hi")
+	assertT.Nil(err)
+	assertT.Equal("HI", v)
+	v, _ = upper("hi")
+	assertT.Equal("HI", v)
+	_, _ = upper("ho")
+	assertT.Equal(2, calls)
+}
+
+func TestMemoizeErrorsNotCached(t *testing.T) {
+	assertT := assert.New(t)
+
+	errBoom := errors.New("boom")
+	calls := 0
+	fn := Memoize(func(n int) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, errBoom
+		}
+		return n * n, nil
+	})
+
+	_, err := fn(3)
+	assertT.Equal(errBoom, err)
+	v, err := fn(3)
+	assertT.Nil(err)
+	assertT.Equal(9, v)
+	assertT.Equal(2, calls)
+}
+
+func TestMemoizeMapWithOptions(t *testing.T) {
+	assertT := assert.New(t)
+
+	square, em := MemoizeMap(func(n int) (int, error) { return n * n, nil },
+		func(em *ExpiryMap[int, int]) *ExpiryMap[int, int] {
+			return em.WithMaxCapacity(2).ExpireAfter(time.Hour)
+		})
+	defer em.Discard()
+
+	for n := 1; n <= 3; n++ {
+		_, _ = square(n)
+	}
+	_, _ = square(3)
+	assertT.Equal([]int{2, 3}, em.Keys())
+	assertT.Equal(time.Hour, em.ExpireTime())
+	assertT.Equal(uint64(1), em.Stats().Hits)
+
+	em.Remove(3)
+	_, _ = square(3)
+	assertT.Equal(uint64(4), em.Stats().Loads)
+
+	em.Discard()
+	_, err := square(3)
+	assertT.Equal(ErrClosed, err)
+}
+
+type point struct{ x, y int }
+
+func TestMemoizeStructKey(t *testing.T) {
+	assertT := assert.New(t)
+
+	calls := 0
+	dist := Memoize(func(p point) (int, error) {
+		calls++
+		return p.x*p.x + p.y*p.y, nil
+	})
+
+	v, _ := dist(point{3, 4})
+	assertT.Equal(25, v)
+	_, _ = dist(point{3, 4})
+	_, _ = dist(point{4, 3})
+	assertT.Equal(2, calls)
+}
+
+func TestMemoize2(t *testing.T) {
+	assertT := assert.New(t)
+
+	calls := 0
+	repeat, em := Memoize2Map(func(s string, n int) (string, error) {
+		calls++
+		return strings.Repeat(s, n), nil
+	})
+	defer em.Discard()
+
+	v, err := repeat("ab", 2)
+	assertT.Nil(err)
+	assertT.Equal("abab", v)
+	_, _ = repeat("ab", 2)
+	_, _ = repeat("ab", 3)
+	assertT.Equal(2, calls)
+	assertT.True(em.ContainsKey(Pair[string, int]{"ab", 3}))
+
+	join := Memoize2(func(a, b string) (string, error) { return a + b, nil })
+	v, _ = join("x", "y")
+	assertT.Equal("xy", v)
+}
+
+func TestMemoizeNoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		square := Memoize(func(n int) (int, error) { return n * n, nil },
+			func(em *ExpiryMap[int, int]) *ExpiryMap[int, int] { return em.ExpireAfter(time.Hour) })
+		_, _ = square(i)
+		join := Memoize2(func(a, b string) (string, error) { return a + b, nil })
+		_, _ = join("x", "y")
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestMemoizeExpiry(t *testing.T) {
+	assertT := assert.New(t)
+
+	calls := 0
+	now, em := MemoizeMap(func(s string) (int, error) {
+		calls++
+		return calls, nil
+	}, func(em *ExpiryMap[string, int]) *ExpiryMap[string, int] { return em.ExpireAfter(sleepTime) })
+	assertT.True(em.IsPassive())
+
+	v, _ := now("Hi")
+	assertT.Equal(1, v)
+	time.Sleep(2 * sleepTime)
+	v, _ = now("Hi")
+	assertT.Equal(2, v)
+}
+
+func TestMemoizeRecursive(t *testing.T) {
+	assertT := assert.New(t)
+
+	calls := 0
+	var fib func(n int) (int, error)
+	fib = Memoize(func(n int) (int, error) {
+		calls++
+		if n < 2 {
+			return n, nil
+		}
+		a, _ := fib(n - 1)
+		b, _ := fib(n - 2)
+		return a + b, nil
+	})
+
+	done := make(chan int, 1)
+	go func() {
+		v, _ := fib(50)
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		assertT.Equal(12586269025, v)
+		assertT.Equal(51, calls)
+	case <-time.After(waitTime):
+		assertT.Fail("memoized recursion deadlocked")
+	}
+}
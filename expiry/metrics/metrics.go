@@ -0,0 +1,269 @@
+// Package "metrics" exports ExpiryMap statistics in Prometheus text exposition format without third-party dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+)
+
+// Default upper bounds of load latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Size of the event subscription buffer used for latency histogram. When the buffer is full the oldest events are dropped,
+// so that a slow consumer doesn't hold up cache operations. Drops are exported as `dropped_events_total`.
+const subscriptionSize = 1024
+
+// Prefix of all metric names
+const prefix = "expiry_cache_"
+
+// Cache as seen by the registry. Implemented by `ExpiryMap`, `ShardedExpiryMap` and `Tiered`.
+type Source[K comparable, V any] interface {
+	Stats() expiry.Stats
+	Len() int
+	SubscribeWithOverflow(bufSize int, overflow expiry.OverflowPolicy, filter ...expiry.EventType) (<-chan expiry.Event[K, V], func())
+}
+
+// Collected data of a registered cache
+type cacheMetrics struct {
+	labels  string // rendered labels without braces, e.g. `cache="users"`
+	stats   func() expiry.Stats
+	length  func() int
+	bounds  []float64 // bucket upper bounds at registration time
+	lock    sync.Mutex
+	buckets []uint64 // cumulative counts are computed on output
+	sum     float64
+	count   uint64
+}
+
+func (cm *cacheMetrics) observe(d time.Duration) {
+	sec := d.Seconds()
+	idx := sort.SearchFloat64s(cm.bounds, sec)
+
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.buckets[idx]++
+	cm.sum += sec
+	cm.count++
+}
+
+// Set of caches exposed on a single endpoint. Implements `http.Handler`.
+type Registry struct {
+	lock    sync.Mutex
+	caches  []*cacheMetrics
+	buckets []float64
+}
+
+// Creates registry with default histogram buckets
+func NewRegistry() *Registry {
+	return &Registry{buckets: DefaultBuckets}
+}
+
+// Modifies upper bounds of load latency histogram buckets in seconds. Affects caches registered afterwards.
+func (r *Registry) WithBuckets(buckets ...float64) *Registry {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.buckets = sorted
+	return r
+}
+
+// Adds the cache to the registry. Labels distinguish caches on the same endpoint, e.g. `{"cache": "users"}`.
+//   - returns a function that removes the cache from the registry
+func Register[K comparable, V any](r *Registry, cache Source[K, V], labels map[string]string) func() {
+	r.lock.Lock()
+	bounds := r.buckets
+	r.lock.Unlock()
+
+	cm := &cacheMetrics{
+		labels:  renderLabels(labels),
+		stats:   cache.Stats,
+		length:  cache.Len,
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)+1),
+	}
+	events, cancel := cache.SubscribeWithOverflow(subscriptionSize, expiry.DropOldest, expiry.Added, expiry.Refreshed, expiry.Failed)
+	go func() {
+		for ev := range events {
+			if ev.Type == expiry.Failed || ev.Duration > 0 {
+				cm.observe(ev.Duration)
+			}
+		}
+	}()
+
+	r.lock.Lock()
+	r.caches = append(r.caches, cm)
+	r.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			for i, c := range r.caches {
+				if c == cm {
+					r.caches = append(r.caches[:i], r.caches[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// Serves metrics of all registered caches
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+type sample struct {
+	cm     *cacheMetrics
+	stats  expiry.Stats
+	length int
+}
+
+type family struct {
+	name  string
+	kind  string
+	help  string
+	value func(s sample) []labeledValue
+}
+
+type labeledValue struct {
+	labels string
+	value  string
+}
+
+func single(v uint64) []labeledValue {
+	return []labeledValue{{value: strconv.FormatUint(v, 10)}}
+}
+
+var families = []family{
+	{"hits_total", "counter", "Number of requests that found the entry.",
+		func(s sample) []labeledValue { return single(s.stats.Hits) }},
+	{"misses_total", "counter", "Number of requests that didn't find the entry.",
+		func(s sample) []labeledValue { return single(s.stats.Misses) }},
+	{"loads_total", "counter", "Number of loader calls by result.",
+		func(s sample) []labeledValue {
+			return []labeledValue{
+				{`result="success"`, strconv.FormatUint(s.stats.Loads, 10)},
+				{`result="failure"`, strconv.FormatUint(s.stats.LoadFailures, 10)},
+			}
+		}},
+	{"evictions_total", "counter", "Number of removed entries by cause.",
+		func(s sample) []labeledValue {
+			return []labeledValue{
+				{`cause="expired"`, strconv.FormatUint(s.stats.Expirations, 10)},
+				{`cause="capacity"`, strconv.FormatUint(s.stats.Evictions, 10)},
+				{`cause="explicit"`, strconv.FormatUint(s.stats.Removals, 10)},
+			}
+		}},
+	{"eviction_vetoes_total", "counter", "Number of evictions and expirations vetoed by the hook.",
+		func(s sample) []labeledValue { return single(s.stats.Vetoes) }},
+	{"refreshes_total", "counter", "Number of early refreshes.",
+		func(s sample) []labeledValue { return single(s.stats.Refreshes) }},
+	{"stale_hits_total", "counter", "Number of stale values served after failed loads.",
+		func(s sample) []labeledValue { return single(s.stats.StaleHits) }},
+	{"load_retries_total", "counter", "Number of retried load attempts.",
+		func(s sample) []labeledValue { return single(s.stats.Retries) }},
+	{"breaker_rejections_total", "counter", "Number of loads rejected by open circuit breaker.",
+		func(s sample) []labeledValue { return single(s.stats.Rejections) }},
+	{"dropped_events_total", "counter", "Number of events dropped by full subscription buffers, including loads missed by the latency histogram.",
+		func(s sample) []labeledValue { return single(s.stats.DroppedEvents) }},
+	{"entries", "gauge", "Current number of entries.",
+		func(s sample) []labeledValue { return single(uint64(s.length)) }},
+	{"pinned_entries", "gauge", "Current number of pinned entries.",
+		func(s sample) []labeledValue { return single(s.stats.Pinned) }},
+}
+
+// Writes metrics of all registered caches in Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	caches := append([]*cacheMetrics{}, r.caches...)
+	r.lock.Unlock()
+
+	samples := make([]sample, len(caches))
+	for i, cm := range caches {
+		samples[i] = sample{cm: cm, stats: cm.stats(), length: cm.length()}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		writeHeader(bw, f.name, f.kind, f.help)
+		for _, s := range samples {
+			for _, lv := range f.value(s) {
+				writeLine(bw, f.name, joinLabels(s.cm.labels, lv.labels), lv.value)
+			}
+		}
+	}
+	writeHistograms(bw, samples)
+	return bw.Flush()
+}
+
+func writeHistograms(bw *bufio.Writer, samples []sample) {
+	name := "load_duration_seconds"
+	writeHeader(bw, name, "histogram", "Duration of loader calls.")
+	for _, s := range samples {
+		cm := s.cm
+		cm.lock.Lock()
+		counts := append([]uint64{}, cm.buckets...)
+		sum, count := cm.sum, cm.count
+		cm.lock.Unlock()
+
+		var cumulative uint64
+		for i, bound := range cm.bounds {
+			cumulative += counts[i]
+			le := `le="` + strconv.FormatFloat(bound, 'g', -1, 64) + `"`
+			writeLine(bw, name+"_bucket", joinLabels(cm.labels, le), strconv.FormatUint(cumulative, 10))
+		}
+		writeLine(bw, name+"_bucket", joinLabels(cm.labels, `le="+Inf"`), strconv.FormatUint(count, 10))
+		writeLine(bw, name+"_sum", cm.labels, strconv.FormatFloat(sum, 'g', -1, 64))
+		writeLine(bw, name+"_count", cm.labels, strconv.FormatUint(count, 10))
+	}
+}
+
+func writeHeader(bw *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(bw, "# HELP %s%s %s\n# TYPE %s%s %s\n", prefix, name, help, prefix, name, kind)
+}
+
+func writeLine(bw *bufio.Writer, name, labels, value string) {
+	if labels == "" {
+		fmt.Fprintf(bw, "%s%s %s\n", prefix, name, value)
+	} else {
+		fmt.Fprintf(bw, "%s%s{%s} %s\n", prefix, name, labels, value)
+	}
+}
+
+func joinLabels(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Renders labels sorted by name
+func renderLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + labelEscaper.Replace(labels[name]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aknopov/handymaps/expiry"
+	"github.com/stretchr/testify/assert"
+)
+
+const waitTime = 500 * time.Millisecond
+
+var errLoad = errors.New("load failed")
+
+func scrape(t *testing.T, reg *Registry) string {
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestExport(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := expiry.NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithLoader(func(key string) (int, error) {
+			if key == "" {
+				return 0, errLoad
+			}
+			time.Sleep(time.Millisecond)
+			return len(key), nil
+		})
+	defer em.Discard()
+
+	reg := NewRegistry()
+	unregister := Register[string, int](reg, em, map[string]string{"cache": "users"})
+	defer unregister()
+
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+	_, _ = em.Get("")
+	em.Remove("Hello")
+
+	assertT.Eventually(func() bool {
+		return strings.Contains(scrape(t, reg), `expiry_cache_load_duration_seconds_count{cache="users"} 3`)
+	}, waitTime, time.Millisecond)
+
+	text := scrape(t, reg)
+	assertT.Contains(text, "# HELP expiry_cache_hits_total Number of requests that found the entry.\n")
+	assertT.Contains(text, "# TYPE expiry_cache_hits_total counter\n")
+	assertT.Contains(text, `expiry_cache_hits_total{cache="users"} 1`+"\n")
+	assertT.Contains(text, `expiry_cache_misses_total{cache="users"} 3`+"\n")
+	assertT.Contains(text, `expiry_cache_loads_total{cache="users",result="success"} 2`+"\n")
+	assertT.Contains(text, `expiry_cache_loads_total{cache="users",result="failure"} 1`+"\n")
+	assertT.Contains(text, `expiry_cache_evictions_total{cache="users",cause="capacity"} 1`+"\n")
+	assertT.Contains(text, `expiry_cache_evictions_total{cache="users",cause="explicit"} 1`+"\n")
+	assertT.Contains(text, `expiry_cache_evictions_total{cache="users",cause="expired"} 0`+"\n")
+	assertT.Contains(text, "# TYPE expiry_cache_entries gauge\n")
+	assertT.Contains(text, `expiry_cache_entries{cache="users"} 0`+"\n")
+	assertT.Contains(text, "# TYPE expiry_cache_load_duration_seconds histogram\n")
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{cache="users",le="10"} 3`+"\n")
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{cache="users",le="+Inf"} 3`+"\n")
+}
+
+func TestHistogramDropsLoads(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := expiry.NewExpiryMap[int, int]().
+		WithLoader(func(key int) (int, error) { return key, nil })
+	defer em.Discard()
+
+	reg := NewRegistry()
+	unregister := Register[int, int](reg, em, nil)
+	defer unregister()
+
+	// the histogram falls behind the loads, but doesn't hold them up
+	loads := 2 * subscriptionSize
+	reg.caches[0].lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < loads; i++ {
+			_, _ = em.Get(i)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(waitTime):
+		t.Fatal("loads blocked by metrics")
+	}
+	reg.caches[0].lock.Unlock()
+
+	dropped := em.Stats().DroppedEvents
+	assertT.Greater(dropped, uint64(0))
+	assertT.Eventually(func() bool {
+		return strings.Contains(scrape(t, reg), "expiry_cache_load_duration_seconds_count "+strconv.FormatUint(uint64(loads)-dropped, 10)+"\n")
+	}, waitTime, time.Millisecond)
+	assertT.Contains(scrape(t, reg), "expiry_cache_dropped_events_total "+strconv.FormatUint(dropped, 10)+"\n")
+}
+
+func TestMultipleCaches(t *testing.T) {
+	assertT := assert.New(t)
+
+	em1 := expiry.NewExpiryMap[string, int]()
+	defer em1.Discard()
+	em2 := expiry.NewShardedExpiryMap[int, string](2).
+		WithLoader(func(key int) (string, error) { return "", nil })
+	defer em2.Discard()
+	em1.Put("Hi", 2)
+	_, _ = em2.Get(1)
+	_, _ = em2.Get(2)
+
+	reg := NewRegistry()
+	defer Register[string, int](reg, em1, map[string]string{"cache": "a"})()
+	unregister := Register[int, string](reg, em2, map[string]string{"cache": "b"})
+
+	text := scrape(t, reg)
+	assertT.Equal(1, strings.Count(text, "# TYPE expiry_cache_entries gauge\n"))
+	assertT.Contains(text, `expiry_cache_entries{cache="a"} 1`+"\n")
+	assertT.Contains(text, `expiry_cache_entries{cache="b"} 2`+"\n")
+
+	unregister()
+	unregister()
+	text = scrape(t, reg)
+	assertT.Contains(text, `expiry_cache_entries{cache="a"} 1`+"\n")
+	assertT.NotContains(text, `cache="b"`)
+}
+
+func TestBuckets(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := expiry.NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(20 * time.Millisecond)
+			return len(key), nil
+		})
+	defer em.Discard()
+
+	reg := NewRegistry().WithBuckets(0.5, 0.01)
+	defer Register[string, int](reg, em, nil)()
+	_, _ = em.Get("Hi")
+
+	assertT.Eventually(func() bool {
+		return strings.Contains(scrape(t, reg), "expiry_cache_load_duration_seconds_count 1\n")
+	}, waitTime, time.Millisecond)
+	text := scrape(t, reg)
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{le="0.01"} 0`+"\n")
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{le="0.5"} 1`+"\n")
+	assertT.Contains(text, "expiry_cache_hits_total 0\n")
+}
+
+func TestBucketsAfterRegister(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := expiry.NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(20 * time.Millisecond)
+			return len(key), nil
+		})
+	defer em.Discard()
+
+	reg := NewRegistry().WithBuckets(0.5, 0.01)
+	defer Register[string, int](reg, em, map[string]string{"cache": "a"})()
+	reg.WithBuckets(1, 2, 3)
+	_, _ = em.Get("Hi")
+
+	assertT.Eventually(func() bool {
+		return strings.Contains(scrape(t, reg), `expiry_cache_load_duration_seconds_count{cache="a"} 1`+"\n")
+	}, waitTime, time.Millisecond)
+	text := scrape(t, reg)
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{cache="a",le="0.01"} 0`+"\n")
+	assertT.Contains(text, `expiry_cache_load_duration_seconds_bucket{cache="a",le="0.5"} 1`+"\n")
+	assertT.NotContains(text, `le="3"`)
+}
+
+func TestRenderLabels(t *testing.T) {
+	assertT := assert.New(t)
+
+	assertT.Equal("", renderLabels(nil))
+	assertT.Equal(`a="1",b="x\"y\\z\n"`, renderLabels(map[string]string{"b": "x\"y\\z\n", "a": "1"}))
+}
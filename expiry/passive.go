@@ -0,0 +1,192 @@
+package expiry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aknopov/handymaps/internal/util"
+)
+
+// Number of the oldest entries checked for expiry by each operation that changes a passive map
+const purgeBatch = 8
+
+// Map that can be cleaned up by `Janitor`
+type Cleaner interface {
+	// Removes expired entries and returns their number
+	Cleanup() int
+}
+
+// Periodically cleans up registered maps with a single goroutine, so that passive maps don't keep expired entries
+// until they are accessed. One janitor can serve any number of maps of different types.
+type Janitor struct {
+	lock     sync.Mutex
+	cleaners *util.Set[Cleaner]
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// Creates janitor that cleans up registered maps with the given interval
+func NewJanitor(interval time.Duration) *Janitor {
+	j := &Janitor{cleaners: util.NewSet[Cleaner](), stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.cleanup()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Adds the map to the maps cleaned up by the janitor
+func (j *Janitor) Register(c Cleaner) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.cleaners.Add(c)
+}
+
+// Removes the map from the maps cleaned up by the janitor
+func (j *Janitor) Unregister(c Cleaner) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.cleaners.Remove(c)
+}
+
+// Stops the janitor goroutine. Subsequent calls have no effect.
+func (j *Janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
+
+func (j *Janitor) cleanup() {
+	j.lock.Lock()
+	cleaners := make([]Cleaner, 0, j.cleaners.Size())
+	for c := range j.cleaners.Enum() {
+		cleaners = append(cleaners, c)
+	}
+	j.lock.Unlock()
+
+	for _, c := range cleaners {
+		c.Cleanup()
+	}
+}
+
+// Registers the map with the janitor. The map unregisters itself on `Close`.
+func (em *ExpiryMap[K, V]) WithJanitor(j *Janitor) *ExpiryMap[K, V] {
+	em.detachJanitor()
+	em.janitor = j
+	if j != nil {
+		j.Register(em)
+	}
+	return em
+}
+
+func (em *ExpiryMap[K, V]) detachJanitor() {
+	if em.janitor != nil {
+		em.janitor.Unregister(em)
+		em.janitor = nil
+	}
+}
+
+// Returns `true` if the map is passive
+func (em *ExpiryMap[K, V]) IsPassive() bool {
+	return em.passive
+}
+
+// Removes expired entries raising `Expired` events. Needed only for passive maps, as others remove entries once they expire.
+//   - returns number of removed entries
+func (em *ExpiryMap[K, V]) Cleanup() int {
+	var count int
+	em.WriteAtomically(func() {
+		count = em.purgeAllExpired()
+	})
+	em.flushEvents()
+	return count
+}
+
+// Removes all expired entries of a passive map and returns number of removed ones. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) purgeAllExpired() int {
+	if !em.passive {
+		return 0
+	}
+	now := time.Now()
+	keys := make([]K, 0)
+	it := em.backMap.Iterator()
+	for it.HasNext() {
+		if key, ent := it.Next(); ent.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	count := 0
+	for _, key := range keys {
+		if em.expire(context.Background(), key) {
+			count++
+		}
+	}
+	return count
+}
+
+func (ent entry[V]) expired(now time.Time) bool {
+	return !ent.expires.IsZero() && !now.Before(ent.expires)
+}
+
+func (ent entry[V]) stopTimer() {
+	if ent.exptmr != nil {
+		ent.exptmr.Stop()
+	}
+}
+
+// Returns the entry unless it is absent or has expired in a passive map. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) getEntry(key K) (entry[V], bool) {
+	ent, ok := em.backMap.Get(key)
+	if ok && em.passive && ent.expired(time.Now()) {
+		return ent, false
+	}
+	return ent, ok
+}
+
+// Removes the key, if it has expired, and a few of the oldest expired entries from a passive map. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) purgeExpired(ctx context.Context, key K) {
+	if !em.passive {
+		return
+	}
+	now := time.Now()
+	if ent, ok := em.backMap.Get(key); ok && ent.expired(now) {
+		em.expire(ctx, key)
+	}
+	keys := em.backMap.Keys()
+	expired := make([]K, 0)
+	for i := 0; i < len(keys) && i < purgeBatch; i++ {
+		if ent, _ := em.backMap.Get(keys[i]); ent.expired(now) {
+			expired = append(expired, keys[i])
+		}
+	}
+	for _, k := range expired {
+		em.expire(ctx, k)
+	}
+}
+
+// Returns keys of the entries in the order they were inserted, skipping expired entries of a passive map. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) liveKeys() []K {
+	if !em.passive {
+		keys := make([]K, em.backMap.Len())
+		copy(keys, em.backMap.Keys())
+		return keys
+	}
+	now := time.Now()
+	keys := make([]K, 0, em.backMap.Len())
+	it := em.backMap.Iterator()
+	for it.HasNext() {
+		if key, ent := it.Next(); !ent.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,113 @@
+package expiry
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassiveNoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		em := NewPassiveExpiryMap[string, int]().ExpireAfter(time.Hour)
+		_ = em.Put("Hi", i)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestPassiveExpiryOnAccess(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[string, int]().
+		ExpireAfter(sleepTime).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Expired)
+	defer cancel()
+
+	_ = em.Put("Hi", 42)
+	_ = em.Put("Hello", 1)
+	assertT.True(em.IsPassive())
+	assertT.Equal(2, em.Len())
+	time.Sleep(2 * sleepTime)
+
+	assertT.False(em.ContainsKey("Hi"))
+	_, ok := em.Peek("Hi")
+	assertT.False(ok)
+	_, ok = em.RemainingTTL("Hi")
+	assertT.False(ok)
+	assertT.Equal(0, em.Len())
+	assertT.Empty(em.Keys())
+	assertT.Equal(0, em.Snapshot().Len())
+	assertT.Equal(0, len(events)) // read operations don't purge
+
+	v, err := em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+	assertT.Equal([]string{"Hi"}, em.Keys())
+
+	// the key being written and the oldest expired entries are purged
+	assertT.Equal(2, len(events))
+	ev := <-events
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(CauseExpired, ev.Cause)
+	assertT.Equal("Hello", (<-events).Key)
+}
+
+func TestPassiveWrites(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[string, int]().ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	_ = em.Put("Hi", 1)
+	_ = em.Put("Hello", 2)
+	time.Sleep(2 * sleepTime)
+
+	assertT.False(em.Replace("Hi", 3))
+	assertT.False(em.Remove("Hello"))
+	assertT.Nil(em.Put("Hi", 4))
+	ttl, ok := em.RemainingTTL("Hi")
+	assertT.True(ok)
+	assertT.Greater(ttl, sleepTime/2) // full time-to-live of a new entry
+	assertT.Equal(uint64(2), em.Stats().Expirations)
+}
+
+func TestCleanup(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[int, int]().ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	for i := 0; i < 2*purgeBatch; i++ {
+		_ = em.Put(i, i)
+	}
+	time.Sleep(2 * sleepTime)
+	assertT.Equal(0, em.Len())
+
+	assertT.Equal(2*purgeBatch, em.Cleanup())
+	assertT.Equal(0, em.Cleanup())
+	assertT.Equal(0, len(em.Keys()))
+}
+
+func TestJanitor(t *testing.T) {
+	assertT := assert.New(t)
+
+	janitor := NewJanitor(sleepTime)
+	defer janitor.Stop()
+	em1 := NewPassiveExpiryMap[string, int]().ExpireAfter(sleepTime).WithJanitor(janitor)
+	defer em1.Discard()
+	em2 := NewPassiveExpiryMap[int, string]().ExpireAfter(sleepTime).WithJanitor(janitor)
+	_ = em1.Put("Hi", 1)
+	_ = em2.Put(1, "Hi")
+
+	assertT.Eventually(func() bool { return em1.Stats().Expirations == 1 }, waitTime, sleepTime)
+	assertT.Eventually(func() bool { return em2.Stats().Expirations == 1 }, waitTime, sleepTime)
+
+	em2.Discard()
+	janitor.lock.Lock()
+	assertT.Equal(1, janitor.cleaners.Size())
+	janitor.lock.Unlock()
+}
@@ -0,0 +1,85 @@
+package expiry
+
+import (
+	"context"
+	"time"
+)
+
+// Makes pinned entries count against map capacity. By default they don't, so that capacity limits only evictable entries.
+// If pinned entries fill the whole capacity, new unpinned entries evict each other.
+func (em *ExpiryMap[K, V]) WithPinnedCounted(counted bool) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.pinnedCounted = counted
+	})
+	return em
+}
+
+// Pins the entry, so that it neither expires nor gets evicted to ensure capacity. It can still be removed with `Remove` or `Clear`.
+//   - returns `false` if there is no mapping for the key
+func (em *ExpiryMap[K, V]) Pin(key K) bool {
+	var ok bool
+	em.WriteAtomically(func() {
+		em.purgeExpired(context.Background(), key)
+		var ent entry[V]
+		if ent, ok = em.backMap.Get(key); ok && !ent.pinned {
+			em.pinEntry(&ent)
+			em.backMap.Put(key, ent)
+		}
+	})
+	em.flushEvents()
+	return ok
+}
+
+// Unpins the entry. It becomes the newest entry with full time-to-live and may evict the oldest entries,
+// if pinned entries are not counted against capacity.
+//   - returns `false` if there is no pinned mapping for the key
+func (em *ExpiryMap[K, V]) Unpin(key K) bool {
+	var ok bool
+	em.WriteAtomically(func() {
+		var ent entry[V]
+		if ent, ok = em.backMap.Get(key); !ok || !ent.pinned {
+			ok = false
+			return
+		}
+		ent.pinned = false
+		em.stats.pinned.Add(-1)
+		ent.created = time.Now()
+		em.schedule(key, &ent, em.entryTTL())
+		em.backMap.Remove(key) // becomes the newest entry
+		em.backMap.Put(key, ent)
+		em.ensureCapacity(context.Background(), em.maxCapacity)
+	})
+	em.flushEvents()
+	return ok
+}
+
+// Same as `Put`, but the entry gets pinned - see `Pin`
+func (em *ExpiryMap[K, V]) PutPinned(key K, val V) error {
+	return em.put(key, val, true)
+}
+
+// Returns `true` if the entry is pinned
+func (em *ExpiryMap[K, V]) IsPinned(key K) bool {
+	var ent entry[V]
+	em.ReadAtomically(func() {
+		ent, _ = em.backMap.Get(key)
+	})
+	return ent.pinned
+}
+
+// Drops expiry timer of the entry and marks it pinned. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) pinEntry(ent *entry[V]) {
+	ent.stopTimer()
+	em.nextGen++ // invalidates expiration that might be on its way
+	ent.gen, ent.exptmr, ent.expires = em.nextGen, nil, time.Time{}
+	ent.pinned = true
+	em.stats.pinned.Add(1)
+}
+
+// Returns number of entries that count against capacity. Must be called with R-lock.
+func (em *ExpiryMap[K, V]) countedLen() int {
+	if em.pinnedCounted {
+		return em.backMap.Len()
+	}
+	return em.backMap.Len() - int(em.stats.pinned.Load())
+}
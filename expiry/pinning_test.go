@@ -0,0 +1,94 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinnedNotExpired(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	assertT.Nil(em.PutPinned("flags", 1))
+	_ = em.Put("Hi", 2)
+	assertT.True(em.Pin("Hi"))
+	assertT.False(em.Pin("Hello"))
+	assertT.True(em.IsPinned("Hi"))
+	ttl, _ := em.RemainingTTL("Hi")
+	assertT.Equal(time.Duration(Eternity), ttl)
+	assertT.Equal(uint64(2), em.Stats().Pinned)
+
+	time.Sleep(3 * sleepTime)
+	assertT.Equal(2, em.Len())
+
+	assertT.True(em.Unpin("Hi"))
+	assertT.False(em.Unpin("Hi"))
+	assertT.False(em.IsPinned("Hi"))
+	assertT.Equal(uint64(1), em.Stats().Pinned)
+	assertT.Eventually(func() bool { return !em.ContainsKey("Hi") }, waitTime, sleepTime)
+
+	assertT.True(em.Remove("flags"))
+	assertT.Equal(uint64(0), em.Stats().Pinned)
+}
+
+func TestPinnedPassive(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[string, int]().ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	_ = em.Put("Hi", 1)
+	assertT.True(em.Pin("Hi"))
+	time.Sleep(2 * sleepTime)
+	assertT.True(em.ContainsKey("Hi"))
+	assertT.Equal(0, em.Cleanup())
+}
+
+func TestPinnedNotEvicted(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().WithMaxCapacity(2)
+	defer em.Discard()
+
+	_ = em.PutPinned(0, 0)
+	for i := 1; i < 5; i++ {
+		_ = em.Put(i, i)
+	}
+	assertT.Equal([]int{0, 3, 4}, em.Keys())
+
+	assertT.True(em.Unpin(0))
+	assertT.Equal([]int{4, 0}, em.Keys()) // unpinned entry is counted again
+	assertT.Equal(2, em.Len())
+}
+
+func TestPinnedCounted(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().
+		WithMaxCapacity(2).
+		WithPinnedCounted(true)
+	defer em.Discard()
+
+	_ = em.PutPinned(0, 0)
+	for i := 1; i < 5; i++ {
+		_ = em.Put(i, i)
+	}
+	assertT.Equal([]int{0, 4}, em.Keys())
+
+	_ = em.PutPinned(5, 5)
+	assertT.Equal([]int{0, 5}, em.Keys())
+
+	// pinned entries fill the capacity - the new entry stays alone
+	_ = em.Put(6, 6)
+	assertT.Equal([]int{0, 5, 6}, em.Keys())
+	em.SetMaxCapacity(1)
+	assertT.Equal([]int{0, 5}, em.Keys())
+	assertT.Equal(uint64(2), em.Stats().Pinned)
+
+	em.Clear()
+	assertT.Equal(uint64(0), em.Stats().Pinned)
+}
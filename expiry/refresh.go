@@ -0,0 +1,129 @@
+package expiry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Keys being refreshed in the background
+type refreshSet[K comparable] struct {
+	lock sync.Mutex
+	keys map[K]struct{}
+}
+
+// Marks the key as being refreshed; returns `false` if it is refreshed already
+func (rs *refreshSet[K]) start(key K) bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if _, ok := rs.keys[key]; ok {
+		return false
+	}
+	rs.keys[key] = struct{}{}
+	return true
+}
+
+func (rs *refreshSet[K]) finish(key K) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	delete(rs.keys, key)
+}
+
+// Makes time-to-live of each entry deviate randomly from the configured one by up to `fraction` of it, from 0 to 1,
+// so that entries loaded together don't expire together. Zero disables jitter, values outside the range are clamped to it.
+func (em *ExpiryMap[K, V]) WithTTLJitter(fraction float64) *ExpiryMap[K, V] {
+	fraction = math.Max(0, math.Min(fraction, 1))
+	em.WriteAtomically(func() {
+		em.ttlJitter = fraction
+	})
+	return em
+}
+
+// Enables probabilistic early refresh (XFetch). A `Get` that finds a loaded entry decides to refresh it
+// with probability that grows as the expiry time approaches, so that popular entries are reloaded before they expire.
+// The entry is refreshed when
+//
+//	now + loadTime * beta * (-ln(rand)) >= expiry time
+//
+// where `loadTime` is the time it took to load the entry, and `rand` is uniform in (0, 1]. Beta 1 is a reasonable default,
+// larger values refresh earlier. Zero disables the mode.
+//
+// Refresh runs on a separate goroutine while `Get` returns the current value, hence the loader must be safe for concurrent use.
+// On success the entry gets the new value and a full time-to-live, and `Refreshed` event is raised; on failure the entry is left to expire.
+// Entries put directly have no load time, hence are never refreshed early.
+func (em *ExpiryMap[K, V]) WithEarlyRefresh(beta float64) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.refreshBeta = beta
+	})
+	return em
+}
+
+// Returns time-to-live of a new entry with jitter applied
+func (em *ExpiryMap[K, V]) entryTTL() time.Duration {
+	if em.ttlJitter <= 0 || em.ttl == Eternity {
+		return em.ttl
+	}
+	ttl := float64(em.ttl) * (1 + em.ttlJitter*(2*rand.Float64()-1))
+	if ttl >= Eternity {
+		return Eternity
+	}
+	return time.Duration(ttl)
+}
+
+// Decides whether the entry should be refreshed now
+func (em *ExpiryMap[K, V]) shouldRefresh(ent entry[V]) bool {
+	if em.refreshBeta <= 0 || ent.expires.IsZero() || ent.loadTime <= 0 {
+		return false
+	}
+	gap := -float64(ent.loadTime) * em.refreshBeta * math.Log(1-rand.Float64())
+	return gap >= float64(time.Until(ent.expires))
+}
+
+// Starts background refresh of the key unless it is refreshed already
+func (em *ExpiryMap[K, V]) startRefresh(ctx context.Context, key K) {
+	if !em.refreshing.start(key) {
+		return
+	}
+	em.stats.refreshes.Add(1)
+	go func() {
+		defer em.refreshing.finish(key)
+		em.refresh(ctx, key)
+		em.flushEvents()
+	}()
+}
+
+// Loads the value without holding the lock and replaces the entry, if it is still present
+func (em *ExpiryMap[K, V]) refresh(ctx context.Context, key K) {
+	start := time.Now()
+	_, span := em.tracer.Start(ctx, OpRefresh, key)
+	val, tags, err := em.invokeLoader(key)
+	elapsed := time.Since(start)
+	em.stats.recordLoad(elapsed, err)
+	defer span.End(loadOutcome(err), elapsed, err)
+
+	if err != nil {
+		em.dispatcher.post(Event[K, V]{Type: Failed, Key: key, Value: val, Err: err, Duration: elapsed})
+		return
+	}
+	em.WriteAtomically(func() {
+		em.purgeExpired(context.Background(), key)
+		ent, ok := em.backMap.Get(key)
+		if !ok || em.IsClosed() {
+			return
+		}
+		if ent.pinned {
+			ent.val, ent.loadTime = val, elapsed
+		} else {
+			ent.stopTimer()
+			access, oldTags := ent.access, ent.tags
+			ent = em.newEntry(key, val, elapsed)
+			ent.access, ent.tags = access, oldTags // so that `tagEntry` drops them from the index
+		}
+		em.backMap.Put(key, ent)
+		em.tagEntry(key, tags)
+		ent, _ = em.backMap.Get(key)
+		em.postEntryEvent(Event[K, V]{Type: Refreshed, Key: key, Value: val, Duration: elapsed}, ent)
+	})
+}
@@ -0,0 +1,185 @@
+package expiry
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func expiresAt[K comparable, V any](em *ExpiryMap[K, V], key K) time.Time {
+	var ent entry[V]
+	em.ReadAtomically(func() {
+		ent, _ = em.backMap.Get(key)
+	})
+	return ent.expires
+}
+
+func TestTTLJitter(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().
+		ExpireAfter(time.Hour).
+		WithTTLJitter(0.5)
+	defer em.Discard()
+
+	minTTL, maxTTL := time.Duration(Eternity), time.Duration(0)
+	for i := 0; i < 100; i++ {
+		_ = em.Put(i, i)
+		ttl, _ := em.RemainingTTL(i)
+		if ttl < minTTL {
+			minTTL = ttl
+		}
+		if ttl > maxTTL {
+			maxTTL = ttl
+		}
+	}
+	assertT.GreaterOrEqual(minTTL, 30*time.Minute-time.Second)
+	assertT.LessOrEqual(maxTTL, 90*time.Minute)
+	assertT.Greater(maxTTL-minTTL, 30*time.Minute)
+}
+
+func TestTTLJitterClamped(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().
+		ExpireAfter(time.Hour).
+		WithTTLJitter(5)
+	defer em.Discard()
+	assertT.Equal(1.0, em.ttlJitter)
+	for i := 0; i < 100; i++ {
+		_ = em.Put(i, i)
+		ttl, _ := em.RemainingTTL(i)
+		assertT.GreaterOrEqual(ttl, time.Duration(0))
+		assertT.LessOrEqual(ttl, 2*time.Hour)
+	}
+
+	em.WithTTLJitter(-1)
+	assertT.Equal(0.0, em.ttlJitter)
+	_ = em.Put(100, 100)
+	ttl, _ := em.RemainingTTL(100)
+	assertT.InDelta(float64(time.Hour), float64(ttl), float64(time.Second))
+}
+
+func TestTTLJitterEternity(t *testing.T) {
+	em := NewExpiryMap[int, int]().WithTTLJitter(0.5)
+	defer em.Discard()
+
+	_ = em.Put(1, 1)
+	ttl, _ := em.RemainingTTL(1)
+	assert.Equal(t, time.Duration(Eternity), ttl)
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	assertT := assert.New(t)
+
+	var calls atomic.Int32
+	tracer := NewRecordingTracer()
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(time.Millisecond)
+			return int(calls.Add(1)), nil
+		}).
+		ExpireAfter(time.Hour).
+		WithEarlyRefresh(1e9). // any load time outweighs the hour left
+		WithTracer(tracer)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Refreshed)
+	defer cancel()
+
+	v, err := em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(1, v)
+	expires1 := expiresAt(em, "Hi")
+
+	v, err = em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(1, v) // current value is returned while refreshing
+
+	ev := <-events
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(2, ev.Value)
+	assertT.Greater(ev.Duration, time.Duration(0))
+	v, _ = em.Peek("Hi")
+	assertT.Equal(2, v)
+	assertT.True(expiresAt(em, "Hi").After(expires1))
+
+	stats := em.Stats()
+	assertT.Equal(uint64(1), stats.Refreshes)
+	assertT.Equal(uint64(2), stats.Loads)
+	assertT.Eventually(func() bool { return len(tracer.Spans()) == 2 }, waitTime, time.Millisecond)
+	assertT.Equal(OpRefresh, tracer.Spans()[1].Op)
+}
+
+func TestNoEarlyRefresh(t *testing.T) {
+	assertT := assert.New(t)
+
+	var calls atomic.Int32
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(time.Millisecond)
+			return int(calls.Add(1)), nil
+		}).
+		ExpireAfter(time.Hour).
+		WithEarlyRefresh(1e-9)
+	defer em.Discard()
+	_ = em.Put("Put", 0)
+
+	for i := 0; i < 100; i++ {
+		_, _ = em.Get("Hi")
+		_, _ = em.Get("Put")
+	}
+	assertT.Equal(int32(1), calls.Load())
+	assertT.Equal(uint64(0), em.Stats().Refreshes)
+}
+
+func TestEarlyRefreshFailure(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			time.Sleep(time.Millisecond)
+			if failing.Load() {
+				return 0, errOrigin
+			}
+			return len(key), nil
+		}).
+		ExpireAfter(time.Hour).
+		WithEarlyRefresh(1e9) // any load time outweighs the hour left
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Failed, Refreshed)
+	defer cancel()
+
+	_, _ = em.Get("Hi")
+	failing.Store(true)
+	v, err := em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+
+	ev := <-events
+	assertT.Equal(Failed, ev.Type)
+	assertT.ErrorIs(ev.Err, errOrigin)
+	assertT.True(em.ContainsKey("Hi"))
+}
+
+func TestStaleTimerIgnored(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().ExpireAfter(time.Hour)
+	defer em.Discard()
+	_ = em.Put("Hi", 2)
+
+	var gen uint64
+	em.ReadAtomically(func() {
+		ent, _ := em.backMap.Get("Hi")
+		gen = ent.gen
+	})
+	em.evictChan <- expiration[string]{"Hi", gen - 1}
+	em.evictChan <- expiration[string]{"Hi", gen - 1} // makes sure the first one is processed
+	assertT.True(em.ContainsKey("Hi"))
+
+	em.evictChan <- expiration[string]{"Hi", gen}
+	assertT.Eventually(func() bool { return !em.ContainsKey("Hi") }, waitTime, time.Millisecond)
+}
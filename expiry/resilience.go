@@ -0,0 +1,284 @@
+package expiry
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// Error returned without invoking the loader while circuit breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// Error returned when a load attempt exceeds its timeout
+	ErrLoadTimeout = errors.New("load attempt timed out")
+)
+
+// Source of time for loader resilience features and stale values. Can be replaced in tests with a fake implementation.
+type Clock interface {
+	// Returns the current time
+	Now() time.Time
+	// Returns a channel that receives the current time after the duration
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Policy of retrying failed load attempts with exponential backoff
+type RetryPolicy struct {
+	// max number of attempts including the first one
+	MaxAttempts int
+	// delay before the first retry
+	InitialBackoff time.Duration
+	// upper limit of the delay; zero means no limit
+	MaxBackoff time.Duration
+	// factor applied to the delay after each retry; values below 1 are treated as 1
+	Multiplier float64
+	// fraction of the delay by which it is randomly increased or decreased, from 0 to 1
+	Jitter float64
+}
+
+// Returns delay before the given retry, counting from 1
+func (rp RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(rp.InitialBackoff) * math.Pow(math.Max(rp.Multiplier, 1), float64(retry-1))
+	if rp.MaxBackoff > 0 {
+		d = math.Min(d, float64(rp.MaxBackoff))
+	}
+	if rp.Jitter > 0 {
+		d *= 1 + rp.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// State of circuit breaker
+type CircuitState int
+
+const (
+	// loads are performed normally
+	CircuitClosed CircuitState = iota
+	// loads fail fast with `ErrCircuitOpen`
+	CircuitOpen
+	// a probe load is allowed to check if the loader has recovered
+	CircuitHalfOpen
+)
+
+// Opens after a number of consecutive failed loads and stays open for a period. Then a single probe load
+// is allowed - on success the breaker closes, on failure it opens again.
+type circuitBreaker struct {
+	lock       sync.Mutex
+	threshold  int
+	openPeriod time.Duration
+	failures   int
+	state      CircuitState
+	openedAt   time.Time
+}
+
+// Checks whether a load is allowed. Returns also the state and whether it has changed.
+func (cb *circuitBreaker) allow(now time.Time) (bool, CircuitState, bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.openPeriod {
+		cb.state = CircuitHalfOpen
+		return true, cb.state, true
+	}
+	return cb.state == CircuitClosed, cb.state, false
+}
+
+// Records result of an allowed load. Returns the new state and whether it has changed.
+func (cb *circuitBreaker) record(err error, now time.Time) (CircuitState, bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	prev := cb.state
+	if err == nil {
+		cb.failures = 0
+		cb.state = CircuitClosed
+	} else {
+		cb.failures++
+		if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+			cb.state = CircuitOpen
+			cb.openedAt = now
+		}
+	}
+	return cb.state, cb.state != prev
+}
+
+func (cb *circuitBreaker) current() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state
+}
+
+var circuitEvents = map[CircuitState]EventType{
+	CircuitClosed:   BreakerClosed,
+	CircuitOpen:     BreakerOpened,
+	CircuitHalfOpen: BreakerHalfOpened,
+}
+
+// Invokes loader applying circuit breaker, retries and attempt timeout, if configured.
+// Tags are returned only by the loader set with `WithTaggedLoader`.
+func (em *ExpiryMap[K, V]) invokeLoader(key K) (V, []string, error) {
+	var zero V
+	cb := em.breaker
+	if cb != nil {
+		allowed, state, changed := cb.allow(em.clock.Now())
+		if changed {
+			em.notifyListeners(circuitEvents[state], key, zero, nil)
+		}
+		if !allowed {
+			em.stats.rejections.Add(1)
+			return zero, nil, ErrCircuitOpen
+		}
+	}
+
+	val, tags, err := em.loadWithRetries(key)
+
+	if cb != nil {
+		if state, changed := cb.record(err, em.clock.Now()); changed {
+			if state == CircuitOpen {
+				em.stats.breakerOpens.Add(1)
+			}
+			em.notifyListeners(circuitEvents[state], key, zero, err)
+		}
+	}
+	return val, tags, err
+}
+
+func (em *ExpiryMap[K, V]) loadWithRetries(key K) (V, []string, error) {
+	for attempt := 1; ; attempt++ {
+		val, tags, err := em.loadAttempt(key)
+		if err == nil || attempt >= em.retry.MaxAttempts {
+			return val, tags, err
+		}
+		em.stats.retries.Add(1)
+		<-em.clock.After(em.retry.backoff(attempt))
+	}
+}
+
+// Returns `true` if a load can wait for backoff or attempt timeout, or the loader calls back into the map,
+// hence the load runs without holding the map lock. Other keys remain accessible meanwhile, while concurrent `Get` calls
+// of the key wait for the same load.
+func (em *ExpiryMap[K, V]) loadsUnlocked() bool {
+	return em.retry.MaxAttempts > 1 || em.attemptTimeout > 0 || em.reentrant
+}
+
+// Load shared by concurrent callers. The result is valid after `done` is closed.
+type sharedLoad[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// Loads in progress by key
+type loadGroup[K comparable, V any] struct {
+	lock  sync.Mutex
+	loads map[K]*sharedLoad[V]
+}
+
+// Invokes `load` unless the key is being loaded already, in which case waits for the result of the ongoing load
+func (lg *loadGroup[K, V]) do(key K, load func() (V, error)) (V, error) {
+	lg.lock.Lock()
+	if sl, ok := lg.loads[key]; ok {
+		lg.lock.Unlock()
+		<-sl.done
+		return sl.val, sl.err
+	}
+	sl := &sharedLoad[V]{done: make(chan struct{})}
+	lg.loads[key] = sl
+	lg.lock.Unlock()
+
+	defer func() {
+		lg.lock.Lock()
+		delete(lg.loads, key)
+		lg.lock.Unlock()
+		close(sl.done)
+	}()
+	sl.val, sl.err = load()
+	return sl.val, sl.err
+}
+
+type loadResult[V any] struct {
+	val  V
+	tags []string
+	err  error
+}
+
+// Invokes loader once. With attempt timeout the loader runs on a separate goroutine that is abandoned on timeout.
+func (em *ExpiryMap[K, V]) loadAttempt(key K) (V, []string, error) {
+	loader := em.taggedLoader
+	if loader == nil {
+		untagged := em.loader
+		loader = func(key K) (V, []string, error) {
+			val, err := untagged(key)
+			return val, nil, err
+		}
+	}
+	if em.attemptTimeout <= 0 {
+		return loader(key)
+	}
+
+	done := make(chan loadResult[V], 1)
+	go func() {
+		val, tags, err := loader(key)
+		done <- loadResult[V]{val, tags, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.val, res.tags, res.err
+	case <-em.clock.After(em.attemptTimeout):
+		var zero V
+		return zero, nil, ErrLoadTimeout
+	}
+}
+
+// Makes the map retry failed loads according to the policy. Retries happen within `Get` call, but without holding the map lock -
+// see `loadsUnlocked`.
+func (em *ExpiryMap[K, V]) WithRetry(policy RetryPolicy) *ExpiryMap[K, V] {
+	em.retry = policy
+	return em
+}
+
+// Limits duration of each load attempt. A timed out attempt fails with `ErrLoadTimeout`. Zero means no limit.
+// Like retries, attempts with a timeout run without holding the map lock.
+func (em *ExpiryMap[K, V]) WithAttemptTimeout(timeout time.Duration) *ExpiryMap[K, V] {
+	em.attemptTimeout = timeout
+	return em
+}
+
+// Adds circuit breaker that opens after `threshold` consecutive failed loads. While it is open, loads fail fast
+// with `ErrCircuitOpen`. After `openPeriod` a single probe load is allowed - it closes the breaker on success
+// or opens it again on failure. State transitions are reported with `BreakerOpened`, `BreakerHalfOpened` and `BreakerClosed` events.
+func (em *ExpiryMap[K, V]) WithCircuitBreaker(threshold int, openPeriod time.Duration) *ExpiryMap[K, V] {
+	em.breaker = &circuitBreaker{threshold: threshold, openPeriod: openPeriod}
+	return em
+}
+
+// Modifies source of time of retry backoff, attempt timeouts, circuit breaker open period, stale values retention
+// and hot-key tracking - the window of `WithHotKeys`, `TopKeys`, `Frequency` and `HotKey` events. Requests tracked so far are dropped.
+// Time-to-live of entries always follows the system time.
+func (em *ExpiryMap[K, V]) WithClock(clock Clock) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.clock = clock
+		if em.hotKeys != nil {
+			em.hotKeys = newHotKeyTracker[K](em.hotKeys.k, em.hotKeys.window, clock.Now())
+		}
+	})
+	return em
+}
+
+// Returns state of circuit breaker; `CircuitClosed` if the breaker is not configured
+func (em *ExpiryMap[K, V]) CircuitState() CircuitState {
+	if em.breaker == nil {
+		return CircuitClosed
+	}
+	return em.breaker.current()
+}
@@ -0,0 +1,302 @@
+package expiry
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// Clock that advances only on request
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+	} else {
+		fc.waiters = append(fc.waiters, fakeWaiter{fc.now.Add(d), ch})
+	}
+	return ch
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.now = fc.now.Add(d)
+	pending := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.deadline.After(fc.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- fc.now
+		}
+	}
+	fc.waiters = pending
+}
+
+func (fc *fakeClock) waiting() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return len(fc.waiters)
+}
+
+// Waits until somebody waits on the clock and advances it
+func (fc *fakeClock) advanceWhenWaiting(t *testing.T, d time.Duration) {
+	assert.Eventually(t, func() bool { return fc.waiting() > 0 }, waitTime, time.Millisecond)
+	fc.Advance(d)
+}
+
+type getResult struct {
+	val int
+	err error
+}
+
+func asyncGet(em *ExpiryMap[string, int], key string) chan getResult {
+	ret := make(chan getResult, 1)
+	go func() {
+		v, err := em.Get(key)
+		ret <- getResult{v, err}
+	}()
+	return ret
+}
+
+func failingLoader(failures *atomic.Int32, calls *atomic.Int32) func(string) (int, error) {
+	return func(key string) (int, error) {
+		calls.Add(1)
+		if failures.Add(-1) >= 0 {
+			return 0, errOrigin
+		}
+		return len(key), nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failures, calls atomic.Int32
+	failures.Store(2)
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().
+		WithLoader(failingLoader(&failures, &calls)).
+		WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Multiplier: 2}).
+		WithClock(clock)
+	defer em.Discard()
+
+	res := asyncGet(em, "Hi")
+	clock.advanceWhenWaiting(t, time.Second)
+	clock.advanceWhenWaiting(t, 2*time.Second)
+
+	r := <-res
+	assertT.Nil(r.err)
+	assertT.Equal(2, r.val)
+	assertT.Equal(int32(3), calls.Load())
+	stats := em.Stats()
+	assertT.Equal(uint64(2), stats.Retries)
+	assertT.Equal(uint64(1), stats.Loads)
+	assertT.Equal(uint64(0), stats.LoadFailures)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failures, calls atomic.Int32
+	failures.Store(5)
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().
+		WithLoader(failingLoader(&failures, &calls)).
+		WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second}).
+		WithClock(clock)
+	defer em.Discard()
+
+	res := asyncGet(em, "Hi")
+	clock.advanceWhenWaiting(t, time.Second)
+
+	r := <-res
+	assertT.ErrorIs(r.err, errOrigin)
+	assertT.Equal(int32(2), calls.Load())
+	assertT.Equal(uint64(1), em.Stats().LoadFailures)
+}
+
+func TestRetryWithoutLock(t *testing.T) {
+	assertT := assert.New(t)
+
+	var calls atomic.Int32
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			if key == "Hi" && calls.Add(1) == 1 {
+				return 0, errOrigin
+			}
+			return len(key), nil
+		}).
+		WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second}).
+		WithClock(clock)
+	defer em.Discard()
+
+	first := asyncGet(em, "Hi")
+	assertT.Eventually(func() bool { return clock.waiting() > 0 }, waitTime, time.Millisecond)
+	second := asyncGet(em, "Hi")
+
+	// the map is accessible during backoff
+	assertT.Nil(em.Put("Ho", 1))
+	v, err := em.Get("Hey")
+	assertT.Nil(err)
+	assertT.Equal(3, v)
+
+	clock.Advance(time.Second)
+	assertT.Equal(getResult{2, nil}, <-first)
+	assertT.Equal(getResult{2, nil}, <-second)
+	assertT.Equal(int32(2), calls.Load()) // concurrent calls share the load
+	assertT.True(em.ContainsKey("Hi"))
+}
+
+func TestBackoff(t *testing.T) {
+	assertT := assert.New(t)
+
+	rp := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	assertT.Equal(time.Second, rp.backoff(1))
+	assertT.Equal(2*time.Second, rp.backoff(2))
+	assertT.Equal(4*time.Second, rp.backoff(3))
+	assertT.Equal(5*time.Second, rp.backoff(4))
+
+	rp = RetryPolicy{InitialBackoff: time.Second}
+	assertT.Equal(time.Second, rp.backoff(3))
+
+	rp = RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := rp.backoff(1)
+		assertT.GreaterOrEqual(d, 500*time.Millisecond)
+		assertT.LessOrEqual(d, 1500*time.Millisecond)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	assertT := assert.New(t)
+
+	release := make(chan bool)
+	defer close(release)
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			<-release
+			return len(key), nil
+		}).
+		WithAttemptTimeout(time.Second).
+		WithClock(clock)
+	defer em.Discard()
+
+	res := asyncGet(em, "Hi")
+	clock.advanceWhenWaiting(t, time.Second)
+
+	r := <-res
+	assertT.ErrorIs(r.err, ErrLoadTimeout)
+	assertT.False(em.ContainsKey("Hi"))
+}
+
+func TestAttemptWithinTimeout(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithAttemptTimeout(time.Hour)
+	defer em.Discard()
+
+	v, err := em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failures, calls atomic.Int32
+	failures.Store(3)
+	clock := newFakeClock()
+	em := NewExpiryMap[string, int]().
+		WithLoader(failingLoader(&failures, &calls)).
+		WithCircuitBreaker(2, 10*time.Second).
+		WithClock(clock)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, BreakerOpened, BreakerHalfOpened, BreakerClosed)
+	defer cancel()
+
+	assertT.Equal(CircuitClosed, em.CircuitState())
+	_, err := em.Get("Hi")
+	assertT.ErrorIs(err, errOrigin)
+	_, err = em.Get("Hi")
+	assertT.ErrorIs(err, errOrigin)
+	assertT.Equal(CircuitOpen, em.CircuitState())
+	assertT.Equal(BreakerOpened, (<-events).Type)
+
+	_, err = em.Get("Hi")
+	assertT.ErrorIs(err, ErrCircuitOpen)
+	assertT.Equal(int32(2), calls.Load())
+
+	// failed probe
+	clock.Advance(10 * time.Second)
+	_, err = em.Get("Hi")
+	assertT.ErrorIs(err, errOrigin)
+	assertT.Equal(BreakerHalfOpened, (<-events).Type)
+	assertT.Equal(BreakerOpened, (<-events).Type)
+	assertT.Equal(CircuitOpen, em.CircuitState())
+
+	// successful probe
+	clock.Advance(10 * time.Second)
+	v, err := em.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+	assertT.Equal(BreakerHalfOpened, (<-events).Type)
+	assertT.Equal(BreakerClosed, (<-events).Type)
+	assertT.Equal(CircuitClosed, em.CircuitState())
+
+	stats := em.Stats()
+	assertT.Equal(uint64(2), stats.BreakerOpens)
+	assertT.Equal(uint64(1), stats.Rejections)
+}
+
+func TestCircuitBreakerHalfOpenSingleProbe(t *testing.T) {
+	assertT := assert.New(t)
+
+	cb := &circuitBreaker{threshold: 1, openPeriod: time.Second}
+	now := time.Now()
+	state, changed := cb.record(errors.New("failure"), now)
+	assertT.Equal(CircuitOpen, state)
+	assertT.True(changed)
+
+	allowed, _, _ := cb.allow(now)
+	assertT.False(allowed)
+
+	allowed, state, changed = cb.allow(now.Add(time.Second))
+	assertT.True(allowed)
+	assertT.Equal(CircuitHalfOpen, state)
+	assertT.True(changed)
+
+	allowed, _, changed = cb.allow(now.Add(time.Second))
+	assertT.False(allowed)
+	assertT.False(changed)
+}
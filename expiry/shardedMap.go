@@ -0,0 +1,690 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aknopov/handymaps/internal/util"
+	"github.com/aknopov/handymaps/ordered"
+)
+
+// ExpiryMap split into independent segments. Keys are distributed among segments by their hash,
+// so that operations on different segments don't contend for the same lock.
+// Capacity is split evenly between segments, hence the oldest entry is evicted per segment, not globally.
+type ShardedExpiryMap[K comparable, V any] struct {
+	shards         []*ExpiryMap[K, V]
+	hash           func(K) uint64
+	maxCapacity    atomic.Int64
+	unsubscribeInv func()
+	warm           *warmup
+	closed         atomic.Bool
+	stopChan       chan struct{}
+}
+
+// Creates sharded map with the given number of segments and default field values - unlimited capacity without entries expiry
+func NewShardedExpiryMap[K comparable, V any](shards int) *ShardedExpiryMap[K, V] {
+	return NewShardedExpiryMapWithContext[K, V](context.Background(), shards)
+}
+
+// Creates sharded map like `NewShardedExpiryMap` that gets closed when the context is cancelled
+func NewShardedExpiryMapWithContext[K comparable, V any](ctx context.Context, shards int) *ShardedExpiryMap[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	ret := &ShardedExpiryMap[K, V]{
+		shards:   make([]*ExpiryMap[K, V], shards),
+		hash:     util.NewHasher[K](),
+		stopChan: make(chan struct{}),
+	}
+	ret.maxCapacity.Store(Unlimited)
+	for i := range ret.shards {
+		ret.shards[i] = NewExpiryMapWithContext[K, V](ctx)
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = ret.Close()
+			case <-ret.stopChan:
+			}
+		}()
+	}
+	return ret
+}
+
+func (sm *ShardedExpiryMap[K, V]) shard(key K) *ExpiryMap[K, V] {
+	return sm.shards[sm.hash(key)%uint64(len(sm.shards))]
+}
+
+// Modifies hash function that distributes keys among segments
+func (sm *ShardedExpiryMap[K, V]) WithHasher(hash func(K) uint64) *ShardedExpiryMap[K, V] {
+	sm.hash = hash
+	return sm
+}
+
+// Modifies time-to-live jitter of all segments
+func (sm *ShardedExpiryMap[K, V]) WithTTLJitter(fraction float64) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithTTLJitter(fraction)
+	}
+	return sm
+}
+
+// Enables probabilistic early refresh in all segments - see `ExpiryMap.WithEarlyRefresh`
+func (sm *ShardedExpiryMap[K, V]) WithEarlyRefresh(beta float64) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithEarlyRefresh(beta)
+	}
+	return sm
+}
+
+// Modifies tracer of all segments
+func (sm *ShardedExpiryMap[K, V]) WithTracer(tracer Tracer) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithTracer(tracer)
+	}
+	return sm
+}
+
+// Attaches the map to the invalidator. Segments share the instance identity, so that the map
+// publishes and applies messages as a whole - see `ExpiryMap.WithInvalidator`. `nil` detaches the map.
+func (sm *ShardedExpiryMap[K, V]) WithInvalidator(inv Invalidator[K]) *ShardedExpiryMap[K, V] {
+	sm.detachInvalidator()
+	instanceID := newInstanceID()
+	for _, s := range sm.shards {
+		s.attachInvalidator(inv, instanceID)
+	}
+	if inv != nil {
+		sm.unsubscribeInv = inv.Subscribe(func(msg Invalidation[K]) {
+			if !msg.All {
+				sm.shard(msg.Key).applyInvalidation(msg)
+				return
+			}
+			for _, s := range sm.shards {
+				s.applyInvalidation(msg)
+			}
+		})
+	}
+	return sm
+}
+
+func (sm *ShardedExpiryMap[K, V]) detachInvalidator() {
+	if sm.unsubscribeInv != nil {
+		sm.unsubscribeInv()
+		sm.unsubscribeInv = nil
+	}
+}
+
+// Modifies max capacity of the map. Each segment gets an equal share of the capacity, give or take one entry.
+func (sm *ShardedExpiryMap[K, V]) WithMaxCapacity(maxCapacity int) *ShardedExpiryMap[K, V] {
+	sm.SetMaxCapacity(maxCapacity)
+	return sm
+}
+
+// Modifes map entries time-to-live period
+func (sm *ShardedExpiryMap[K, V]) ExpireAfter(ttl time.Duration) *ShardedExpiryMap[K, V] {
+	sm.SetTTL(ttl, false)
+	return sm
+}
+
+// Changes max capacity of a live map, evicting the oldest entries of segments that exceed their share - see `ExpiryMap.SetMaxCapacity`
+func (sm *ShardedExpiryMap[K, V]) SetMaxCapacity(maxCapacity int) {
+	sm.maxCapacity.Store(int64(maxCapacity))
+	for i, s := range sm.shards {
+		s.SetMaxCapacity(sm.shardCapacity(i, maxCapacity))
+	}
+}
+
+// Share of the capacity for i-th segment. The first `maxCapacity % shards` segments get one more entry,
+// so that shares sum up to the capacity.
+func (sm *ShardedExpiryMap[K, V]) shardCapacity(i int, maxCapacity int) int {
+	if maxCapacity == Unlimited {
+		return Unlimited
+	}
+	n := len(sm.shards)
+	if i < maxCapacity%n {
+		return maxCapacity/n + 1
+	}
+	return maxCapacity / n
+}
+
+// Changes time-to-live period of all segments - see `ExpiryMap.SetTTL`
+func (sm *ShardedExpiryMap[K, V]) SetTTL(ttl time.Duration, applyToExisting bool) {
+	for _, s := range sm.shards {
+		s.SetTTL(ttl, applyToExisting)
+	}
+}
+
+// Modifes map's loader that provides values for a new  key
+func (sm *ShardedExpiryMap[K, V]) WithLoader(loader func(key K) (V, error)) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithLoader(loader)
+	}
+	return sm
+}
+
+// Modifes loader of all segments to the one that also returns tags - see `ExpiryMap.WithTaggedLoader`
+func (sm *ShardedExpiryMap[K, V]) WithTaggedLoader(loader func(key K) (V, []string, error)) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithTaggedLoader(loader)
+	}
+	return sm
+}
+
+// Enables tracking of the most requested keys in all segments - see `ExpiryMap.WithHotKeys`.
+// Each segment tracks `k` keys, so that the top keys of the map are found among them.
+func (sm *ShardedExpiryMap[K, V]) WithHotKeys(k int, window time.Duration) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithHotKeys(k, window)
+	}
+	return sm
+}
+
+// Sets request rate per second at which a key becomes hot in all segments - see `ExpiryMap.WithHotKeyRate`
+func (sm *ShardedExpiryMap[K, V]) WithHotKeyRate(rate float64) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithHotKeyRate(rate)
+	}
+	return sm
+}
+
+// Switches delivery of events to dedicated goroutines - one per segment.
+func (sm *ShardedExpiryMap[K, V]) WithAsyncListeners(bufSize int, overflow OverflowPolicy) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithAsyncListeners(bufSize, overflow)
+	}
+	return sm
+}
+
+// Restores the default synchronous delivery of events.
+func (sm *ShardedExpiryMap[K, V]) WithSyncListeners() *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithSyncListeners()
+	}
+	return sm
+}
+
+// Modifies policy applied to subscribers that don't keep up with events.
+func (sm *ShardedExpiryMap[K, V]) WithSubscriptionOverflow(overflow OverflowPolicy) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithSubscriptionOverflow(overflow)
+	}
+	return sm
+}
+
+// Makes `Put`, `Replace` and `Remove` of all segments write synchronously to the writer - see `ExpiryMap.WithWriteThrough`
+func (sm *ShardedExpiryMap[K, V]) WithWriteThrough(writer Writer[K, V]) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithWriteThrough(writer)
+	}
+	return sm
+}
+
+// Makes segments queue writes to the writer - see `ExpiryMap.WithWriteBehind`. Each segment has its own queue,
+// so that `batchSize` applies per segment and the writer must be safe for concurrent use.
+func (sm *ShardedExpiryMap[K, V]) WithWriteBehind(writer Writer[K, V], interval time.Duration, batchSize int, maxRetries int) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithWriteBehind(writer, interval, batchSize, maxRetries)
+	}
+	return sm
+}
+
+// Makes all segments retain expired values for up to `maxStale` period - see `ExpiryMap.StaleIfError`
+func (sm *ShardedExpiryMap[K, V]) StaleIfError(maxStale time.Duration) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.StaleIfError(maxStale)
+	}
+	return sm
+}
+
+// Makes all segments retry failed loads according to the policy - see `ExpiryMap.WithRetry`
+func (sm *ShardedExpiryMap[K, V]) WithRetry(policy RetryPolicy) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithRetry(policy)
+	}
+	return sm
+}
+
+// Limits duration of each load attempt in all segments - see `ExpiryMap.WithAttemptTimeout`
+func (sm *ShardedExpiryMap[K, V]) WithAttemptTimeout(timeout time.Duration) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithAttemptTimeout(timeout)
+	}
+	return sm
+}
+
+// Adds circuit breaker to each segment - see `ExpiryMap.WithCircuitBreaker`. Breakers of segments
+// count failures independently, so that a failing origin opens them one after another.
+func (sm *ShardedExpiryMap[K, V]) WithCircuitBreaker(threshold int, openPeriod time.Duration) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithCircuitBreaker(threshold, openPeriod)
+	}
+	return sm
+}
+
+// Modifies source of time of all segments - see `ExpiryMap.WithClock`
+func (sm *ShardedExpiryMap[K, V]) WithClock(clock Clock) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithClock(clock)
+	}
+	return sm
+}
+
+// Limits number of loaders invoked in parallel by `Preload` - see `ExpiryMap.WithPreloadConcurrency`
+func (sm *ShardedExpiryMap[K, V]) WithPreloadConcurrency(n int) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithPreloadConcurrency(n)
+	}
+	return sm
+}
+
+// Runs `load` once on a background goroutine and seeds the map with the entries it returns - see `ExpiryMap.WithWarmup`.
+// Completion raises a single `WarmedUp` event.
+func (sm *ShardedExpiryMap[K, V]) WithWarmup(load func(ctx context.Context) (map[K]V, error)) *ShardedExpiryMap[K, V] {
+	sm.warm = startWarmup(sm.shards[0], load, sm.PutAll)
+	return sm
+}
+
+// Makes pinned entries count against capacity of their segments - see `ExpiryMap.WithPinnedCounted`
+func (sm *ShardedExpiryMap[K, V]) WithPinnedCounted(counted bool) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithPinnedCounted(counted)
+	}
+	return sm
+}
+
+// Sets a hook that is asked by all segments before an entry expires or is evicted - see `ExpiryMap.BeforeEvict`.
+// The hook is called while a segment is locked for writing, possibly for several segments in parallel.
+func (sm *ShardedExpiryMap[K, V]) BeforeEvict(hook func(key K, val V, cause Cause) bool) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.BeforeEvict(hook)
+	}
+	return sm
+}
+
+// Bounds vetoes of `BeforeEvict` hook in all segments - see `ExpiryMap.WithEvictionRetries`
+func (sm *ShardedExpiryMap[K, V]) WithEvictionRetries(maxVetoes int, delay time.Duration) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithEvictionRetries(maxVetoes, delay)
+	}
+	return sm
+}
+
+// Makes events of all segments carry entry metadata - see `ExpiryMap.WithEntryInfoInEvents`
+func (sm *ShardedExpiryMap[K, V]) WithEntryInfoInEvents(enabled bool) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.WithEntryInfoInEvents(enabled)
+	}
+	return sm
+}
+
+// Returns number of segments
+func (sm *ShardedExpiryMap[K, V]) Shards() int {
+	return len(sm.shards)
+}
+
+// Returns map capacity
+func (sm *ShardedExpiryMap[K, V]) Capacity() int {
+	return int(sm.maxCapacity.Load())
+}
+
+// Returns expiry period
+func (sm *ShardedExpiryMap[K, V]) ExpireTime() time.Duration {
+	return sm.shards[0].ExpireTime()
+}
+
+// Returns length of the map
+func (sm *ShardedExpiryMap[K, V]) Len() int {
+	size := 0
+	for _, s := range sm.shards {
+		size += s.Len()
+	}
+	return size
+}
+
+// Returns cumulative statistics of all segments
+func (sm *ShardedExpiryMap[K, V]) Stats() Stats {
+	var stats Stats
+	for _, s := range sm.shards {
+		stats = stats.Plus(s.Stats())
+	}
+	return stats
+}
+
+// Returns the most restrictive state of segment breakers - `CircuitOpen` if any of them is open,
+// otherwise `CircuitHalfOpen` if any of them is probing the origin
+func (sm *ShardedExpiryMap[K, V]) CircuitState() CircuitState {
+	state := CircuitClosed
+	for _, s := range sm.shards {
+		switch s.CircuitState() {
+		case CircuitOpen:
+			return CircuitOpen
+		case CircuitHalfOpen:
+			state = CircuitHalfOpen
+		}
+	}
+	return state
+}
+
+// Returns `true` if the map has been closed
+func (sm *ShardedExpiryMap[K, V]) IsClosed() bool {
+	return sm.shards[0].IsClosed()
+}
+
+// Closes all segments. Subsequent calls have no effect.
+func (sm *ShardedExpiryMap[K, V]) Close() error {
+	if !sm.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(sm.stopChan)
+	sm.detachInvalidator()
+	for _, s := range sm.shards {
+		_ = s.Close()
+	}
+	return nil
+}
+
+// Same as `Close`
+func (sm *ShardedExpiryMap[K, V]) Discard() {
+	_ = sm.Close()
+}
+
+// Returns a value associated with the given key. It can invoke `load` function if entry is not present in the map.
+func (sm *ShardedExpiryMap[K, V]) Get(key K) (V, error) {
+	return sm.shard(key).Get(key)
+}
+
+// Same as `Get`, but tracer spans caused by the call are started with the context.
+func (sm *ShardedExpiryMap[K, V]) GetCtx(ctx context.Context, key K) (V, error) {
+	return sm.shard(key).GetCtx(ctx, key)
+}
+
+// Returns the value associated to the given key without triggering the loader.
+func (sm *ShardedExpiryMap[K, V]) Peek(key K) (V, bool) {
+	return sm.shard(key).Peek(key)
+}
+
+// Returns `true`, if there is a mapping for the specified key.
+func (sm *ShardedExpiryMap[K, V]) ContainsKey(key K) bool {
+	return sm.shard(key).ContainsKey(key)
+}
+
+// Returns time left until the entry expires, or `Eternity` if the entry doesn't expire.
+//   - the second value is `false` if there is no mapping for the key
+func (sm *ShardedExpiryMap[K, V]) RemainingTTL(key K) (time.Duration, bool) {
+	return sm.shard(key).RemainingTTL(key)
+}
+
+// Returns metadata of the entry - see `ExpiryMap.Inspect`
+//   - the second value is `false` if there is no mapping for the key
+func (sm *ShardedExpiryMap[K, V]) Inspect(key K) (EntryInfo, bool) {
+	return sm.shard(key).Inspect(key)
+}
+
+// Associates the value with the key - see `ExpiryMap.Put`
+func (sm *ShardedExpiryMap[K, V]) Put(key K, val V) error {
+	return sm.shard(key).Put(key, val)
+}
+
+// Seeds the map with the entries, each segment under a single lock - see `ExpiryMap.PutAll`
+func (sm *ShardedExpiryMap[K, V]) PutAll(entries map[K]V) error {
+	shardEntries := make(map[*ExpiryMap[K, V]]map[K]V)
+	for key, val := range entries {
+		s := sm.shard(key)
+		if shardEntries[s] == nil {
+			shardEntries[s] = make(map[K]V)
+		}
+		shardEntries[s][key] = val
+	}
+	for s, entries := range shardEntries {
+		if err := s.PutAll(entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads values of the keys that are not in the map yet - see `ExpiryMap.Preload`.
+// Segments are preloaded one after another, so that the concurrency limit applies to the map as a whole.
+//   - returns when all keys are processed with errors of failed loads joined, or `ErrClosed` if the map has been closed
+func (sm *ShardedExpiryMap[K, V]) Preload(keys []K) error {
+	perShard := make(map[*ExpiryMap[K, V]][]K)
+	for _, key := range keys {
+		s := sm.shard(key)
+		perShard[s] = append(perShard[s], key)
+	}
+	errs := make([]error, 0)
+	for _, s := range sm.shards {
+		if err := s.Preload(perShard[s]); err == ErrClosed {
+			return err
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Waits for completion of the warm-up set with `WithWarmup` and returns its error - see `ExpiryMap.AwaitWarmup`
+func (sm *ShardedExpiryMap[K, V]) AwaitWarmup(ctx context.Context) error {
+	return sm.warm.await(ctx)
+}
+
+// Returns `true` if the warm-up set with `WithWarmup` has completed successfully or if there is no warm-up
+func (sm *ShardedExpiryMap[K, V]) IsWarm() bool {
+	return sm.warm.isWarm()
+}
+
+// Pins the entry, so that it neither expires nor gets evicted - see `ExpiryMap.Pin`
+//   - returns `false` if there is no mapping for the key
+func (sm *ShardedExpiryMap[K, V]) Pin(key K) bool {
+	return sm.shard(key).Pin(key)
+}
+
+// Unpins the entry - see `ExpiryMap.Unpin`
+//   - returns `false` if there is no pinned mapping for the key
+func (sm *ShardedExpiryMap[K, V]) Unpin(key K) bool {
+	return sm.shard(key).Unpin(key)
+}
+
+// Same as `Put`, but the entry gets pinned - see `Pin`
+func (sm *ShardedExpiryMap[K, V]) PutPinned(key K, val V) error {
+	return sm.shard(key).PutPinned(key, val)
+}
+
+// Returns `true` if the entry is pinned
+func (sm *ShardedExpiryMap[K, V]) IsPinned(key K) bool {
+	return sm.shard(key).IsPinned(key)
+}
+
+// Replaces the entry for a key if present.
+//   - return `true` if value was replaced
+func (sm *ShardedExpiryMap[K, V]) Replace(key K, val V) bool {
+	return sm.shard(key).Replace(key, val)
+}
+
+// Removes the mapping for a key from the cache if it is present.
+//   - return `true` if value was removed
+func (sm *ShardedExpiryMap[K, V]) Remove(key K) bool {
+	return sm.shard(key).Remove(key)
+}
+
+// Clears the cache. Segments are cleared one after another. Other instances are told to clear their entries once, if configured.
+func (sm *ShardedExpiryMap[K, V]) Clear() {
+	for _, s := range sm.shards {
+		s.clear()
+	}
+	s := sm.shards[0]
+	if !s.IsClosed() {
+		var zero K
+		s.publishInvalidation(zero, true)
+	}
+	for _, s := range sm.shards {
+		s.flushEvents()
+	}
+}
+
+// Writes pending write-behind operations of all segments immediately
+func (sm *ShardedExpiryMap[K, V]) Flush() {
+	for _, s := range sm.shards {
+		s.Flush()
+	}
+}
+
+// Returns number of write-behind operations that are not flushed yet
+func (sm *ShardedExpiryMap[K, V]) PendingWrites() int {
+	count := 0
+	for _, s := range sm.shards {
+		count += s.PendingWrites()
+	}
+	return count
+}
+
+// Removes entries for which the predicate returns `true`, segment by segment - see `ExpiryMap.RemoveIf`
+func (sm *ShardedExpiryMap[K, V]) RemoveIf(pred func(key K, val V) bool) int {
+	count := 0
+	for _, s := range sm.shards {
+		count += s.RemoveIf(pred)
+	}
+	return count
+}
+
+// Removes entries of the keys - see `ExpiryMap.InvalidateAll`
+func (sm *ShardedExpiryMap[K, V]) InvalidateAll(keys []K) int {
+	shardKeys := make(map[*ExpiryMap[K, V]][]K)
+	for _, key := range keys {
+		s := sm.shard(key)
+		shardKeys[s] = append(shardKeys[s], key)
+	}
+	count := 0
+	for s, keys := range shardKeys {
+		count += s.InvalidateAll(keys)
+	}
+	return count
+}
+
+// Removes entries which loader returned the tag, segment by segment - see `ExpiryMap.InvalidateTag`
+func (sm *ShardedExpiryMap[K, V]) InvalidateTag(tag string) int {
+	count := 0
+	for _, s := range sm.shards {
+		count += s.InvalidateTag(tag)
+	}
+	return count
+}
+
+// Returns up to `n` most requested keys of all segments, the most requested first - see `ExpiryMap.TopKeys`
+func (sm *ShardedExpiryMap[K, V]) TopKeys(n int) []KeyFrequency[K] {
+	var keys []KeyFrequency[K]
+	for _, s := range sm.shards {
+		keys = append(keys, s.TopKeys(n)...)
+	}
+	if keys == nil {
+		return nil
+	}
+	return topOf(keys, n)
+}
+
+// Returns estimated number of requests of the key within the window - see `ExpiryMap.Frequency`
+func (sm *ShardedExpiryMap[K, V]) Frequency(key K) uint64 {
+	return sm.shard(key).Frequency(key)
+}
+
+// Returns the map keys, segment by segment
+func (sm *ShardedExpiryMap[K, V]) Keys() []K {
+	keys := make([]K, 0)
+	for _, s := range sm.shards {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+// Returns a copy of the map entries. Each segment is copied atomically, but not the map as a whole.
+func (sm *ShardedExpiryMap[K, V]) Snapshot() *ordered.OrderedMap[K, V] {
+	snapshot := ordered.NewOrderedMap[K, V]()
+	for _, s := range sm.shards {
+		snapshot.PutAll(s.Snapshot())
+	}
+	return snapshot
+}
+
+// Calls `f` for each entry segment by segment. Iteration stops if `f` returns `false`.
+func (sm *ShardedExpiryMap[K, V]) Range(f func(key K, val V) bool) {
+	stopped := false
+	for _, s := range sm.shards {
+		s.Range(func(key K, val V) bool {
+			stopped = !f(key, val)
+			return !stopped
+		})
+		if stopped {
+			break
+		}
+	}
+}
+
+// Adds listener to events of all segments.
+func (sm *ShardedExpiryMap[K, V]) AddListener(listener Listener[K, V]) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.AddListener(listener)
+	}
+	return sm
+}
+
+// Removes listener to events.
+func (sm *ShardedExpiryMap[K, V]) RemoveListener(listener Listener[K, V]) *ShardedExpiryMap[K, V] {
+	for _, s := range sm.shards {
+		s.RemoveListener(listener)
+	}
+	return sm
+}
+
+// Subscribes to events of all segments through a single channel. See `ExpiryMap.Subscribe`.
+func (sm *ShardedExpiryMap[K, V]) Subscribe(bufSize int, filter ...EventType) (<-chan Event[K, V], func()) {
+	return sm.subscribe(bufSize, func(s *ExpiryMap[K, V]) (<-chan Event[K, V], func()) {
+		return s.Subscribe(bufSize, filter...)
+	})
+}
+
+// Same as `Subscribe`, but subscriptions to segments are handled according to the given policy - see `ExpiryMap.SubscribeWithOverflow`
+func (sm *ShardedExpiryMap[K, V]) SubscribeWithOverflow(bufSize int, overflow OverflowPolicy, filter ...EventType) (<-chan Event[K, V], func()) {
+	return sm.subscribe(bufSize, func(s *ExpiryMap[K, V]) (<-chan Event[K, V], func()) {
+		return s.SubscribeWithOverflow(bufSize, overflow, filter...)
+	})
+}
+
+// Merges subscriptions to all segments into a single channel
+func (sm *ShardedExpiryMap[K, V]) subscribe(bufSize int, subscribe func(s *ExpiryMap[K, V]) (<-chan Event[K, V], func())) (<-chan Event[K, V], func()) {
+	out := make(chan Event[K, V], bufSize)
+	done := make(chan struct{})
+	cancels := make([]func(), len(sm.shards))
+	var wg sync.WaitGroup
+	for i, s := range sm.shards {
+		var in <-chan Event[K, V]
+		in, cancels[i] = subscribe(s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range in {
+				select {
+				case out <- e:
+				case <-done:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			close(done)
+			for _, cancel := range cancels {
+				cancel()
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const shards = 8
+
+func TestShardedCreation(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithMaxCapacity(maxCapacity).
+		ExpireAfter(ttl)
+	defer sm.Discard()
+
+	assertT.Equal(shards, sm.Shards())
+	assertT.Equal(maxCapacity, sm.Capacity())
+	assertT.Equal(ttl, sm.ExpireTime())
+	assertT.Equal(0, sm.Len())
+	total := 0
+	for _, s := range sm.shards {
+		assertT.InDelta(maxCapacity/shards, s.Capacity(), 1)
+		total += s.Capacity()
+	}
+	assertT.Equal(maxCapacity, total)
+
+	assertT.Equal(1, NewShardedExpiryMap[string, int](0).Shards())
+}
+
+func TestShardedOperations(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+
+	for i := 0; i < 100; i++ {
+		v, err := sm.Get(strconv.Itoa(i))
+		assertT.Nil(err)
+		assertT.Equal(len(strconv.Itoa(i)), v)
+	}
+	assertT.Equal(100, sm.Len())
+	assertT.Len(sm.Keys(), 100)
+	assertT.Equal(100, sm.Snapshot().Len())
+	for _, s := range sm.shards {
+		assertT.Greater(s.Len(), 0)
+	}
+
+	assertT.True(sm.ContainsKey("42"))
+	assertT.True(sm.Replace("42", 7))
+	v, ok := sm.Peek("42")
+	assertT.True(ok)
+	assertT.Equal(7, v)
+	assertT.True(sm.Remove("42"))
+	assertT.False(sm.ContainsKey("42"))
+
+	count := 0
+	sm.Range(func(key string, val int) bool {
+		count++
+		return count < 10
+	})
+	assertT.Equal(10, count)
+
+	stats := sm.Stats()
+	assertT.Equal(uint64(100), stats.Loads)
+	assertT.Equal(uint64(1), stats.Hits)
+	assertT.Equal(uint64(1), stats.Removals)
+
+	sm.Clear()
+	assertT.Equal(0, sm.Len())
+
+	assertT.False(sm.IsClosed())
+	assertT.Nil(sm.Close())
+	assertT.True(sm.IsClosed())
+	_, err := sm.Get("Hi")
+	assertT.ErrorIs(err, ErrClosed)
+}
+
+func TestShardedCapacity(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[int, int](shards).
+		WithMaxCapacity(2 * shards).
+		WithLoader(func(key int) (int, error) { return key, nil })
+	defer sm.Discard()
+
+	for i := 0; i < 1000; i++ {
+		_, _ = sm.Get(i)
+	}
+	assertT.Equal(2*shards, sm.Len())
+}
+
+func TestShardedCapacityShares(t *testing.T) {
+	assertT := assert.New(t)
+
+	for _, capacity := range []int{1, shards - 1, shards + 3, 3*shards + 5} {
+		sm := NewShardedExpiryMap[int, int](shards).
+			WithMaxCapacity(capacity).
+			WithLoader(func(key int) (int, error) { return key, nil })
+
+		for i := 0; i < 1000; i++ {
+			v, err := sm.Get(i)
+			assertT.Nil(err)
+			assertT.Equal(i, v)
+		}
+		assertT.LessOrEqual(sm.Len(), capacity)
+		sm.Discard()
+	}
+
+	sm := NewShardedExpiryMap[int, int](shards).WithMaxCapacity(1)
+	defer sm.Discard()
+	for i := 0; i < shards; i++ {
+		sm.Put(i, i)
+	}
+	assertT.Equal(1, sm.Len())
+}
+
+func TestShardedCloseWithContext(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := NewShardedExpiryMapWithContext[string, int](ctx, shards).
+		WithInvalidator(bus)
+	sm.Put("Hi", 2)
+
+	cancel()
+	assertT.Eventually(sm.IsClosed, waitTime, sleepTime)
+	assertT.Eventually(func() bool { return len(bus.snapshot()) == 0 }, waitTime, sleepTime)
+	assertT.Equal(0, sm.Len())
+}
+
+func TestShardedFloatKeys(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[float64, int](shards)
+	defer sm.Discard()
+
+	sm.Put(0.0, 1)
+	sm.Put(math.Copysign(0, -1), 2)
+	assertT.Equal(1, sm.Len())
+	v, ok := sm.Peek(0.0)
+	assertT.True(ok)
+	assertT.Equal(2, v)
+}
+
+func TestShardedEvents(t *testing.T) {
+	assertT := assert.New(t)
+
+	var added atomic.Int32
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) {
+			if ev == Added {
+				added.Add(1)
+			}
+		}})
+	events, cancel := sm.Subscribe(100, Added)
+
+	for i := 0; i < 20; i++ {
+		_, _ = sm.Get(strconv.Itoa(i))
+	}
+	assertT.Equal(int32(20), added.Load())
+
+	received := make(map[string]bool)
+	for len(received) < 20 {
+		ev := <-events
+		assertT.Equal(Added, ev.Type)
+		received[ev.Key] = true
+	}
+
+	cancel()
+	cancel()
+	for range events {
+	}
+	sm.Discard()
+}
+
+func benchmarkParallel(b *testing.B, get func(key int) (int, error)) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = get(i % 1024)
+			i++
+		}
+	})
+}
+
+func BenchmarkExpiryMapParallel(b *testing.B) {
+	em := NewExpiryMap[int, int]().
+		WithLoader(func(key int) (int, error) { return key, nil })
+	defer em.Discard()
+
+	benchmarkParallel(b, em.Get)
+}
+
+// Compare with `BenchmarkExpiryMapParallel` using `-cpu 1,2,4,8,16`
+func BenchmarkShardedExpiryMapParallel(b *testing.B) {
+	sm := NewShardedExpiryMap[int, int](64).
+		WithLoader(func(key int) (int, error) { return key, nil })
+	defer sm.Discard()
+
+	benchmarkParallel(b, sm.Get)
+}
+
+func TestShardedTracer(t *testing.T) {
+	assertT := assert.New(t)
+
+	tracer := NewRecordingTracer()
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithTracer(tracer)
+	defer sm.Discard()
+
+	for i := 0; i < 10; i++ {
+		_, _ = sm.GetCtx(context.Background(), strconv.Itoa(i))
+	}
+	assertT.Len(tracer.Spans(), 10)
+	_, ok := sm.RemainingTTL("1")
+	assertT.True(ok)
+}
+
+func TestShardedWrites(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	sm := NewShardedExpiryMap[string, int](shards).WithWriteThrough(writer)
+	defer sm.Discard()
+
+	assertT.Nil(sm.Put("a", 1))
+	assertT.Nil(sm.PutAll(map[string]int{"b": 2, "c": 3, "d": 4}))
+	assertT.Equal(4, sm.Len())
+	v, _ := sm.Peek("c")
+	assertT.Equal(3, v)
+	_, data := writer.snapshot()
+	assertT.Equal(map[string]int{"a": 1}, data) // `PutAll` is not propagated to the writer
+
+	writer = newRecordingWriter()
+	sm.WithWriteBehind(writer, time.Hour, 0, 0)
+	for i := 0; i < 20; i++ {
+		assertT.Nil(sm.Put(strconv.Itoa(i), i))
+	}
+	assertT.True(sm.Remove("a"))
+	assertT.Equal(21, sm.PendingWrites())
+	sm.Flush()
+	assertT.Equal(0, sm.PendingWrites())
+	ops, data := writer.snapshot()
+	assertT.Equal(21, len(ops))
+	assertT.Equal(20, len(data))
+
+	assertT.Nil(sm.Close())
+	assertT.ErrorIs(sm.Put("x", 1), ErrClosed)
+	assertT.ErrorIs(sm.PutAll(map[string]int{"x": 1}), ErrClosed)
+}
+
+func TestShardedStaleIfError(t *testing.T) {
+	assertT := assert.New(t)
+
+	var fail atomic.Bool
+	sm := NewShardedExpiryMap[string, int](shards).
+		ExpireAfter(sleepTime).
+		StaleIfError(time.Hour).
+		WithLoader(func(key string) (int, error) {
+			if fail.Load() {
+				return 0, errors.New("down")
+			}
+			return len(key), nil
+		})
+	defer sm.Discard()
+
+	_, _ = sm.Get("Hi")
+	fail.Store(true)
+	assertT.Eventually(func() bool { return !sm.ContainsKey("Hi") }, waitTime, sleepTime)
+	v, err := sm.Get("Hi")
+	assertT.Equal(2, v)
+	assertT.ErrorIs(err, ErrStale)
+}
+
+func TestShardedResilience(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failures, calls atomic.Int32
+	failures.Store(3)
+	clock := newFakeClock()
+	sm := NewShardedExpiryMap[string, int](2).
+		WithHasher(func(key string) uint64 { return uint64(len(key)) }).
+		WithLoader(failingLoader(&failures, &calls)).
+		WithRetry(RetryPolicy{MaxAttempts: 2}).
+		WithCircuitBreaker(1, 10*time.Second).
+		WithClock(clock)
+	defer sm.Discard()
+
+	assertT.Equal(CircuitClosed, sm.CircuitState())
+	_, err := sm.Get("Hi")
+	assertT.ErrorIs(err, errOrigin)
+	assertT.Equal(int32(2), calls.Load())
+	assertT.Equal(CircuitOpen, sm.CircuitState())
+
+	// the other segment has its own breaker
+	v, err := sm.Get("Hey")
+	assertT.Nil(err)
+	assertT.Equal(3, v)
+	assertT.Equal(CircuitOpen, sm.CircuitState())
+
+	clock.Advance(10 * time.Second)
+	v, err = sm.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+	assertT.Equal(CircuitClosed, sm.CircuitState())
+}
+
+func TestShardedPreload(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) {
+			if key == "fail" {
+				return 0, errOrigin
+			}
+			return len(key), nil
+		}).
+		WithPreloadConcurrency(2)
+	defer sm.Discard()
+
+	err := sm.Preload([]string{"a", "bb", "ccc", "dddd", "fail"})
+	assertT.ErrorIs(err, errOrigin)
+	assertT.Equal(4, sm.Len())
+	v, _ := sm.Peek("ccc")
+	assertT.Equal(3, v)
+
+	sm.Discard()
+	assertT.ErrorIs(sm.Preload([]string{"e"}), ErrClosed)
+}
+
+func TestShardedWarmup(t *testing.T) {
+	assertT := assert.New(t)
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithWarmup(func(ctx context.Context) (map[string]int, error) {
+			calls.Add(1)
+			<-release
+			return map[string]int{"a": 1, "b": 2, "c": 3}, nil
+		})
+	defer sm.Discard()
+	events, cancel := sm.Subscribe(10, WarmedUp)
+	defer cancel()
+
+	assertT.False(sm.IsWarm())
+	close(release)
+	assertT.Nil(sm.AwaitWarmup(context.Background()))
+	assertT.True(sm.IsWarm())
+	assertT.Equal(3, sm.Len())
+	assertT.Equal(int32(1), calls.Load())
+
+	assertT.Equal(WarmedUp, (<-events).Type)
+	assertT.Never(func() bool { return len(events) > 0 }, 5*sleepTime, sleepTime)
+
+	cold := NewShardedExpiryMap[string, int](shards)
+	defer cold.Discard()
+	assertT.True(cold.IsWarm())
+}
+
+func TestShardedPinning(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](2).
+		WithHasher(func(key string) uint64 { return uint64(len(key)) }).
+		WithMaxCapacity(2).
+		WithPinnedCounted(true).
+		ExpireAfter(sleepTime)
+	defer sm.Discard()
+
+	assertT.Nil(sm.PutPinned("a", 1))
+	assertT.Nil(sm.Put("bb", 2))
+	assertT.True(sm.Pin("bb"))
+	assertT.True(sm.IsPinned("a"))
+	assertT.True(sm.IsPinned("bb"))
+	assertT.False(sm.Pin("ccc"))
+
+	// segment of "a" is full with the pinned entry
+	assertT.Nil(sm.Put("c", 3))
+	assertT.Eventually(func() bool { return !sm.ContainsKey("c") }, waitTime, sleepTime)
+	assertT.True(sm.ContainsKey("a"))
+	assertT.True(sm.ContainsKey("bb"))
+
+	assertT.True(sm.Unpin("a"))
+	assertT.False(sm.Unpin("a"))
+	assertT.False(sm.IsPinned("a"))
+	assertT.Eventually(func() bool { return !sm.ContainsKey("a") }, waitTime, sleepTime)
+	assertT.True(sm.ContainsKey("bb"))
+}
+
+func TestShardedVeto(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[int, int](2).
+		WithHasher(func(key int) uint64 { return uint64(key) }).
+		WithMaxCapacity(4).
+		BeforeEvict(func(key int, val int, cause Cause) bool { return key > 1 }).
+		WithEvictionRetries(1, time.Second)
+	defer sm.Discard()
+
+	for i := 0; i < 8; i++ {
+		_ = sm.Put(i, i)
+	}
+	// each eviction vetoes the oldest key of the segment once and evicts the next candidate without asking
+	assertT.ElementsMatch([]int{0, 1, 6, 7}, sm.Keys())
+	assertT.Equal(uint64(4), sm.Stats().Vetoes)
+}
+
+func TestShardedInspect(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](shards).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithEntryInfoInEvents(true).
+		ExpireAfter(time.Hour)
+	defer sm.Discard()
+	events, cancel := sm.Subscribe(10, Added)
+	defer cancel()
+
+	_, _ = sm.Get("Hi")
+	_, _ = sm.Get("Hi")
+	info, ok := sm.Inspect("Hi")
+	assertT.True(ok)
+	assertT.Equal(uint64(1), info.Accesses) // the load is not an access
+	assertT.LessOrEqual(info.RemainingTTL, time.Hour)
+	_, ok = sm.Inspect("Ho")
+	assertT.False(ok)
+
+	ev := <-events
+	assertT.NotNil(ev.Info)
+}
@@ -0,0 +1,72 @@
+package expiry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aknopov/handymaps/ordered"
+)
+
+// Error wrapped together with the loader error when `Get` returns a stale value
+var ErrStale = errors.New("stale value served")
+
+type staleEntry[V any] struct {
+	val      V
+	deadline time.Time
+}
+
+// Shadow area that retains expired values for a limited time
+type staleArea[K comparable, V any] struct {
+	lock    sync.Mutex
+	entries *ordered.OrderedMap[K, staleEntry[V]]
+}
+
+func newStaleArea[K comparable, V any]() *staleArea[K, V] {
+	return &staleArea[K, V]{entries: ordered.NewOrderedMap[K, staleEntry[V]]()}
+}
+
+func (sa *staleArea[K, V]) keep(key K, val V, now time.Time, maxStale time.Duration) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.purge(now)
+	sa.entries.Remove(key)
+	sa.entries.Put(key, staleEntry[V]{val: val, deadline: now.Add(maxStale)})
+}
+
+func (sa *staleArea[K, V]) get(key K, now time.Time) (V, bool) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.purge(now)
+	ent, ok := sa.entries.Get(key)
+	return ent.val, ok
+}
+
+func (sa *staleArea[K, V]) remove(key K) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.entries.Remove(key)
+}
+
+func (sa *staleArea[K, V]) clear() {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.entries = ordered.NewOrderedMap[K, staleEntry[V]]()
+}
+
+func (sa *staleArea[K, V]) len() int {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.entries.Len()
+}
+
+// Drops entries past their deadline. Entries are kept in the order of deadlines, so it stops at the first live one.
+func (sa *staleArea[K, V]) purge(now time.Time) {
+	for sa.entries.Len() > 0 {
+		key := sa.entries.Keys()[0]
+		if ent, _ := sa.entries.Get(key); ent.deadline.After(now) {
+			return
+		}
+		sa.entries.Remove(key)
+	}
+}
@@ -0,0 +1,136 @@
+package expiry
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errOrigin = errors.New("origin is down")
+
+func newFlakyMap(failing *atomic.Bool) *ExpiryMap[string, int] {
+	return NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			if failing.Load() {
+				return 0, errOrigin
+			}
+			return len(key), nil
+		}).
+		ExpireAfter(sleepTime)
+}
+
+func TestStaleIfError(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	em := newFlakyMap(&failing).StaleIfError(time.Hour)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, ServedStale)
+	defer cancel()
+
+	_, _ = em.Get("Hi")
+	assertT.Eventually(func() bool { return em.Len() == 0 }, waitTime, sleepTime)
+
+	failing.Store(true)
+	v, err := em.Get("Hi")
+	assertT.Equal(2, v)
+	assertT.ErrorIs(err, ErrStale)
+	assertT.ErrorIs(err, errOrigin)
+	assertT.False(em.ContainsKey("Hi"))
+	assertT.Equal(uint64(1), em.Stats().StaleHits)
+
+	ev := <-events
+	assertT.Equal(ServedStale, ev.Type)
+	assertT.Equal("Hi", ev.Key)
+	assertT.Equal(2, ev.Value)
+
+	v, err = em.Get("Hello")
+	assertT.Equal(0, v)
+	assertT.ErrorIs(err, errOrigin)
+	assertT.NotErrorIs(err, ErrStale)
+
+	failing.Store(false)
+	v, err = em.Get("Hi")
+	assertT.Equal(2, v)
+	assertT.Nil(err)
+	assertT.Equal(0, em.stale.len())
+}
+
+func TestPassiveStaleIfError(t *testing.T) {
+	assertT := assert.New(t)
+
+	// with retries the load runs without the map lock
+	for _, attempts := range []int{1, 2} {
+		var failing atomic.Bool
+		em := NewPassiveExpiryMap[string, int]().
+			WithLoader(func(key string) (int, error) {
+				if failing.Load() {
+					return 0, errOrigin
+				}
+				return len(key), nil
+			}).
+			WithRetry(RetryPolicy{MaxAttempts: attempts}).
+			ExpireAfter(sleepTime).
+			StaleIfError(time.Hour)
+		defer em.Discard()
+
+		_, _ = em.Get("Hi")
+		time.Sleep(2 * sleepTime)
+		assertT.False(em.ContainsKey("Hi"))
+
+		failing.Store(true)
+		v, err := em.Get("Hi")
+		assertT.Equal(2, v)
+		assertT.ErrorIs(err, ErrStale)
+		assertT.Equal(uint64(1), em.Stats().StaleHits)
+		assertT.Equal(uint64(1), em.Stats().Expirations)
+	}
+}
+
+func TestStaleExpires(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	em := newFlakyMap(&failing).StaleIfError(sleepTime)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	assertT.Eventually(func() bool { return em.Len() == 0 }, waitTime, sleepTime)
+	time.Sleep(2 * sleepTime)
+
+	failing.Store(true)
+	_, err := em.Get("Hi")
+	assertT.NotErrorIs(err, ErrStale)
+}
+
+func TestStaleDroppedOnRemove(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	em := newFlakyMap(&failing).StaleIfError(time.Hour)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hello")
+	assertT.Eventually(func() bool { return em.stale.len() == 2 }, waitTime, sleepTime)
+
+	em.Remove("Hi")
+	assertT.Equal(1, em.stale.len())
+	em.Clear()
+	assertT.Equal(0, em.stale.len())
+}
+
+func TestStaleDisabled(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	em := newFlakyMap(&failing)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	assertT.Eventually(func() bool { return em.Len() == 0 }, waitTime, sleepTime)
+	assertT.Equal(0, em.stale.len())
+}
@@ -0,0 +1,132 @@
+package expiry
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Cumulative statistics of ExpiryMap operations
+type Stats struct {
+	// number of `Get` and `Peek` calls that found the entry
+	Hits uint64
+	// number of `Get` calls that invoked loader and `Peek` calls that didn't find the entry
+	Misses uint64
+	// number of successful loads
+	Loads uint64
+	// number of failed loads
+	LoadFailures uint64
+	// total time spent in loader
+	LoadTime time.Duration
+	// number of early refreshes started by `Get`
+	Refreshes uint64
+	// number of stale values returned after failed loads
+	StaleHits uint64
+	// number of retried load attempts
+	Retries uint64
+	// number of times circuit breaker opened
+	BreakerOpens uint64
+	// number of loads rejected by open circuit breaker
+	Rejections uint64
+	// number of entries removed after their time-to-live
+	Expirations uint64
+	// number of entries removed to ensure capacity
+	Evictions uint64
+	// number of entries removed explicitly
+	Removals uint64
+	// current number of pinned entries
+	Pinned uint64
+	// number of evictions and expirations vetoed by `BeforeEvict` hook
+	Vetoes uint64
+	// number of events discarded by full subscription channels or asynchronous listener queue
+	DroppedEvents uint64
+}
+
+// Returns ratio of hits to all requests, or zero if there were no requests
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Returns sum of two statistics
+func (s Stats) Plus(other Stats) Stats {
+	return Stats{
+		Hits:          s.Hits + other.Hits,
+		Misses:        s.Misses + other.Misses,
+		Loads:         s.Loads + other.Loads,
+		LoadFailures:  s.LoadFailures + other.LoadFailures,
+		LoadTime:      s.LoadTime + other.LoadTime,
+		Refreshes:     s.Refreshes + other.Refreshes,
+		StaleHits:     s.StaleHits + other.StaleHits,
+		Retries:       s.Retries + other.Retries,
+		BreakerOpens:  s.BreakerOpens + other.BreakerOpens,
+		Rejections:    s.Rejections + other.Rejections,
+		Expirations:   s.Expirations + other.Expirations,
+		Evictions:     s.Evictions + other.Evictions,
+		Removals:      s.Removals + other.Removals,
+		Pinned:        s.Pinned + other.Pinned,
+		Vetoes:        s.Vetoes + other.Vetoes,
+		DroppedEvents: s.DroppedEvents + other.DroppedEvents,
+	}
+}
+
+// Lock-free counters behind `Stats`
+type statsCounters struct {
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	loads        atomic.Uint64
+	loadFailures atomic.Uint64
+	loadTime     atomic.Int64
+	refreshes    atomic.Uint64
+	staleHits    atomic.Uint64
+	retries      atomic.Uint64
+	breakerOpens atomic.Uint64
+	rejections   atomic.Uint64
+	expirations  atomic.Uint64
+	evictions    atomic.Uint64
+	removals     atomic.Uint64
+	pinned       atomic.Int64 // changed under W-lock
+	vetoes       atomic.Uint64
+}
+
+func (sc *statsCounters) recordLoad(elapsed time.Duration, err error) {
+	if err == nil {
+		sc.loads.Add(1)
+	} else {
+		sc.loadFailures.Add(1)
+	}
+	sc.loadTime.Add(int64(elapsed))
+}
+
+func (sc *statsCounters) recordRemoval(cause Cause) {
+	switch cause {
+	case CauseExpired:
+		sc.expirations.Add(1)
+	case CauseCapacity:
+		sc.evictions.Add(1)
+	default:
+		sc.removals.Add(1)
+	}
+}
+
+func (sc *statsCounters) snapshot() Stats {
+	return Stats{
+		Hits:         sc.hits.Load(),
+		Misses:       sc.misses.Load(),
+		Loads:        sc.loads.Load(),
+		LoadFailures: sc.loadFailures.Load(),
+		LoadTime:     time.Duration(sc.loadTime.Load()),
+		Refreshes:    sc.refreshes.Load(),
+		StaleHits:    sc.staleHits.Load(),
+		Retries:      sc.retries.Load(),
+		BreakerOpens: sc.breakerOpens.Load(),
+		Rejections:   sc.rejections.Load(),
+		Expirations:  sc.expirations.Load(),
+		Evictions:    sc.evictions.Load(),
+		Removals:     sc.removals.Load(),
+		Pinned:       uint64(sc.pinned.Load()),
+		Vetoes:       sc.vetoes.Load(),
+	}
+}
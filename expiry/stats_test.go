@@ -0,0 +1,52 @@
+package expiry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(2).
+		WithLoader(func(key string) (int, error) {
+			if key == "" {
+				return 0, errors.New("empty key")
+			}
+			return len(key), nil
+		}).
+		ExpireAfter(sleepTime)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("")
+	_, _ = em.Peek("Hello")
+	_, _ = em.Get("Hello")
+	_, _ = em.Get("World!")
+	em.Remove("World!")
+
+	stats := em.Stats()
+	assertT.Equal(uint64(1), stats.Hits)
+	assertT.Equal(uint64(5), stats.Misses)
+	assertT.Equal(uint64(3), stats.Loads)
+	assertT.Equal(uint64(1), stats.LoadFailures)
+	assertT.Equal(uint64(1), stats.Evictions)
+	assertT.Equal(uint64(1), stats.Removals)
+	assertT.InDelta(1.0/6.0, stats.HitRatio(), 1e-9)
+
+	assertT.Eventually(func() bool { return em.Stats().Expirations == 1 }, waitTime, sleepTime)
+}
+
+func TestStatsPlus(t *testing.T) {
+	assertT := assert.New(t)
+
+	s1 := Stats{Hits: 1, Misses: 2, Loads: 3, LoadFailures: 4, LoadTime: 5, StaleHits: 9, Retries: 10, BreakerOpens: 11, Rejections: 12, Expirations: 6, Evictions: 7, Removals: 8, DroppedEvents: 13}
+	s2 := Stats{Hits: 10, Misses: 20, Loads: 30, LoadFailures: 40, LoadTime: 50, StaleHits: 90, Retries: 100, BreakerOpens: 110, Rejections: 120, Expirations: 60, Evictions: 70, Removals: 80, DroppedEvents: 130}
+
+	assertT.Equal(Stats{Hits: 11, Misses: 22, Loads: 33, LoadFailures: 44, LoadTime: 55, StaleHits: 99, Retries: 110, BreakerOpens: 121, Rejections: 132, Expirations: 66, Evictions: 77, Removals: 88, DroppedEvents: 143}, s1.Plus(s2))
+	assertT.Equal(0.0, Stats{}.HitRatio())
+}
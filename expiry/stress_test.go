@@ -0,0 +1,192 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var stressDuration = flag.Duration("stress", time.Second, "duration of the randomized stress test")
+
+const (
+	stressWorkers  = 8
+	stressKeys     = 64
+	stressCapacity = 32
+)
+
+// Counts additions and removals per key
+type balanceListener struct {
+	lock    sync.Mutex
+	added   map[string]int
+	removed map[string]int
+}
+
+func (bl *balanceListener) Listen(ev EventType, key string, val int, err error) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	switch ev {
+	case Added:
+		bl.added[key]++
+	case Expired, Removed:
+		bl.removed[key]++
+	}
+}
+
+// Returns keys which were removed more often or less often than added
+func (bl *balanceListener) unbalanced() []string {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	keys := make([]string, 0)
+	for i := 0; i < stressKeys; i++ {
+		key := "k" + strconv.Itoa(i)
+		if bl.added[key] != bl.removed[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+var errStress = errors.New("unlucky key")
+
+func stressOperation(em *ExpiryMap[string, int], r *rand.Rand) {
+	key := "k" + strconv.Itoa(r.Intn(stressKeys))
+	switch op := r.Intn(100); {
+	case op < 30:
+		_, _ = em.Get(key)
+	case op < 35:
+		_, _ = em.GetCtx(context.Background(), key)
+	case op < 45:
+		_, _ = em.Peek(key)
+	case op < 55:
+		_ = em.Put(key, r.Int())
+	case op < 62:
+		em.Replace(key, r.Int())
+	case op < 70:
+		em.Remove(key)
+	case op < 74:
+		em.ContainsKey(key)
+	case op < 78:
+		em.RemainingTTL(key)
+		em.Inspect(key)
+	case op < 82:
+		em.Keys()
+	case op < 85:
+		em.Snapshot()
+	case op < 87:
+		em.Range(func(k string, v int) bool { return r.Intn(4) > 0 })
+	case op < 88:
+		if r.Intn(2) == 0 {
+			em.RemoveIf(func(k string, v int) bool { return v%7 == 0 })
+		} else {
+			em.InvalidateTag("t" + strconv.Itoa(r.Intn(3)))
+		}
+	case op < 92:
+		em.Len()
+		em.Stats()
+		em.TopKeys(3)
+	case op < 94:
+		ch, cancel := em.Subscribe(1)
+		if r.Intn(2) == 0 {
+			<-ch
+		}
+		cancel()
+	case op < 96:
+		em.Cleanup()
+	case op < 97:
+		listener := &ListenerWarapper{func(ev EventType, key string, val int, err error) {}}
+		em.AddListener(listener)
+		em.RemoveListener(listener)
+	case op < 99:
+		em.SetMaxCapacity(stressCapacity - r.Intn(stressCapacity/2))
+		em.SetTTL(time.Duration(1+r.Intn(10))*time.Millisecond, r.Intn(2) == 0)
+	default:
+		em.Clear()
+	}
+}
+
+// Checks consistency of the backing map under the read lock
+func checkInvariants(t *testing.T, em *ExpiryMap[string, int]) bool {
+	ok := true
+	em.ReadAtomically(func() {
+		keys := em.backMap.Keys()
+		ok = em.backMap.Len() == len(keys) && len(keys) <= stressCapacity
+		for _, key := range keys {
+			_, present := em.backMap.Get(key)
+			ok = ok && present
+		}
+	})
+	return assert.True(t, ok, "backing map is inconsistent")
+}
+
+func TestStress(t *testing.T) {
+	runStress(t, NewExpiryMap[string, int]())
+}
+
+func TestStressPassive(t *testing.T) {
+	runStress(t, NewPassiveExpiryMap[string, int]())
+}
+
+func runStress(t *testing.T, em *ExpiryMap[string, int]) {
+	assertT := assert.New(t)
+
+	duration := *stressDuration
+	if testing.Short() {
+		duration /= 10
+	}
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d, duration %v", seed, duration)
+
+	balance := &balanceListener{added: make(map[string]int), removed: make(map[string]int)}
+	em.WithMaxCapacity(stressCapacity).
+		ExpireAfter(5*time.Millisecond).
+		WithTTLJitter(0.5).
+		WithEarlyRefresh(1).
+		StaleIfError(5*time.Millisecond).
+		BeforeEvict(func(key string, val int, cause Cause) bool { return val%3 != 0 }).
+		WithEvictionRetries(2, time.Millisecond).
+		WithHotKeys(8, 10*time.Millisecond).
+		WithHotKeyRate(1000).
+		WithTaggedLoader(func(key string) (int, []string, error) {
+			if key == "k13" {
+				return 0, nil, errStress
+			}
+			time.Sleep(10 * time.Microsecond)
+			return len(key), []string{"t" + strconv.Itoa(len(key)%3)}, nil
+		}).
+		AddListener(balance)
+
+	deadline := time.Now().Add(duration)
+	var workers sync.WaitGroup
+	for i := 0; i < stressWorkers; i++ {
+		workers.Add(1)
+		go func(r *rand.Rand) {
+			defer workers.Done()
+			for time.Now().Before(deadline) {
+				stressOperation(em, r)
+			}
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+
+	for time.Now().Before(deadline) {
+		if !checkInvariants(t, em) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	workers.Wait()
+	checkInvariants(t, em)
+	assertT.Equal(em.Len(), len(em.Keys()))
+
+	assertT.Nil(em.Close())
+	assertT.Equal(0, em.Len())
+	assertT.Empty(em.tags.keys)
+	assertT.Eventually(func() bool { return len(balance.unbalanced()) == 0 }, waitTime, sleepTime,
+		"keys with unbalanced events: %v", balance.unbalanced())
+}
@@ -0,0 +1,125 @@
+package expiry
+
+import "context"
+
+// Secondary index of entries by their tags. Guarded by the map lock.
+type tagIndex[K comparable] struct {
+	keys map[string]map[K]struct{}
+}
+
+func (ti *tagIndex[K]) add(key K, tags []string) {
+	for _, tag := range tags {
+		keys, ok := ti.keys[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			ti.keys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (ti *tagIndex[K]) remove(key K, tags []string) {
+	for _, tag := range tags {
+		if keys, ok := ti.keys[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(ti.keys, tag)
+			}
+		}
+	}
+}
+
+func (ti *tagIndex[K]) get(tag string) []K {
+	keys := make([]K, 0, len(ti.keys[tag]))
+	for key := range ti.keys[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Modifies map's loader to the one that also returns tags of the value, e.g. tenant or upstream record identifiers.
+// Entries can be invalidated by their tags with `InvalidateTag`. Tags are replaced when the entry is reloaded or refreshed,
+// while `Put` and `Replace` keep them. `WithLoader` replaces the tagged loader.
+func (em *ExpiryMap[K, V]) WithTaggedLoader(loader func(key K) (V, []string, error)) *ExpiryMap[K, V] {
+	em.taggedLoader = loader
+	em.loader = func(key K) (V, error) {
+		val, _, err := loader(key)
+		return val, err
+	}
+	return em
+}
+
+// Replaces tags of the entry. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) tagEntry(key K, tags []string) {
+	ent, ok := em.backMap.Get(key)
+	if !ok || (len(ent.tags) == 0 && len(tags) == 0) {
+		return
+	}
+	em.tags.remove(key, ent.tags)
+	ent.tags = tags
+	em.backMap.Put(key, ent)
+	em.tags.add(key, tags)
+}
+
+// Returns tags of the entry
+func (em *ExpiryMap[K, V]) Tags(key K) []string {
+	var ent entry[V]
+	em.ReadAtomically(func() {
+		ent, _ = em.getEntry(key)
+	})
+	return append([]string{}, ent.tags...)
+}
+
+// Removes entries for which the predicate returns `true`. Each removal raises `Removed` event.
+// The predicate is called while the map is locked for writing, hence it must not call back into the map.
+// Removals are not propagated to the writer, while other instances are told to drop the keys, if configured.
+//   - returns number of removed entries
+func (em *ExpiryMap[K, V]) RemoveIf(pred func(key K, val V) bool) int {
+	return em.invalidate(func() []K {
+		keys := make([]K, 0)
+		for _, key := range em.liveKeys() {
+			if ent, _ := em.backMap.Get(key); pred(key, ent.val) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	})
+}
+
+// Removes entries of the keys, like `RemoveIf`.
+//   - returns number of removed entries
+func (em *ExpiryMap[K, V]) InvalidateAll(keys []K) int {
+	return em.invalidate(func() []K {
+		return keys
+	})
+}
+
+// Removes entries which loader returned the tag, like `RemoveIf`.
+//   - returns number of removed entries
+func (em *ExpiryMap[K, V]) InvalidateTag(tag string) int {
+	return em.invalidate(func() []K {
+		return em.tags.get(tag)
+	})
+}
+
+// Removes entries of the keys selected under W-lock and publishes their invalidation
+func (em *ExpiryMap[K, V]) invalidate(selectKeys func() []K) int {
+	removed := make([]K, 0)
+	em.WriteAtomically(func() {
+		if em.IsClosed() {
+			return
+		}
+		for _, key := range selectKeys() {
+			em.purgeExpired(context.Background(), key)
+			if em.removeEntry(key, CauseExplicit) {
+				em.stale.remove(key)
+				removed = append(removed, key)
+			}
+		}
+	})
+	for _, key := range removed {
+		em.publishInvalidation(key, false)
+	}
+	em.flushEvents()
+	return len(removed)
+}
@@ -0,0 +1,131 @@
+package expiry
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Loads "tenant/id" keys tagging them with the tenant
+func tenantLoader(key string) (int, []string, error) {
+	tenant, _, _ := strings.Cut(key, "/")
+	return len(key), []string{"tenant:" + tenant}, nil
+}
+
+func TestRemoveIf(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]()
+	defer em.Discard()
+	for _, key := range []string{"a", "bb", "ccc", "dd"} {
+		_ = em.Put(key, len(key))
+	}
+	events, cancel := em.Subscribe(10, Removed)
+	defer cancel()
+
+	assertT.Equal(2, em.RemoveIf(func(key string, val int) bool { return val == 2 }))
+	assertT.Equal([]string{"a", "ccc"}, em.Keys())
+	assertT.Equal(2, len(events))
+	ev := <-events
+	assertT.Equal("bb", ev.Key)
+	assertT.Equal(CauseExplicit, ev.Cause)
+	assertT.Equal(uint64(2), em.Stats().Removals)
+}
+
+func TestInvalidateAll(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().WithWriteThrough(writer)
+	defer em.Discard()
+	_ = em.Put("a", 1)
+	_ = em.Put("b", 2)
+	_ = em.Put("c", 3)
+
+	assertT.Equal(2, em.InvalidateAll([]string{"a", "c", "d"}))
+	assertT.Equal([]string{"b"}, em.Keys())
+	ops, _ := writer.snapshot()
+	assertT.Equal([]string{"W:a", "W:b", "W:c"}, ops) // not propagated to the writer
+}
+
+func TestInvalidateTag(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[string, int]().WithTaggedLoader(tenantLoader)
+	defer em.Discard()
+	for _, key := range []string{"x/1", "x/2", "y/1"} {
+		_, _ = em.Get(key)
+	}
+	_ = em.Put("x/3", 0) // put entries have no tags
+	assertT.Equal([]string{"tenant:x"}, em.Tags("x/1"))
+	assertT.Empty(em.Tags("x/3"))
+
+	assertT.Equal(2, em.InvalidateTag("tenant:x"))
+	assertT.Equal([]string{"y/1", "x/3"}, em.Keys())
+	assertT.Equal(0, em.InvalidateTag("tenant:x"))
+	assertT.Equal(0, em.InvalidateTag("tenant:z"))
+
+	em.Remove("y/1")
+	assertT.Empty(em.tags.keys)
+}
+
+func TestInvalidateBroadcast(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	em1 := NewExpiryMap[string, int]().WithTaggedLoader(tenantLoader).WithInvalidator(bus)
+	defer em1.Discard()
+	em2 := NewExpiryMap[string, int]().WithInvalidator(bus)
+	defer em2.Discard()
+	_, _ = em1.Get("x/1")
+	_ = em2.Put("x/1", 3)
+	_ = em2.Put("y/1", 3)
+
+	assertT.Equal(1, em1.InvalidateTag("tenant:x"))
+	assertT.Equal([]string{"y/1"}, em2.Keys())
+}
+
+func TestShardedInvalidate(t *testing.T) {
+	assertT := assert.New(t)
+
+	sm := NewShardedExpiryMap[string, int](4).WithTaggedLoader(tenantLoader)
+	defer sm.Discard()
+	for _, key := range []string{"x/1", "x/2", "x/3", "y/1", "y/2", "z/1"} {
+		_, _ = sm.Get(key)
+	}
+
+	assertT.Equal(3, sm.InvalidateTag("tenant:x"))
+	assertT.Equal(2, sm.InvalidateAll([]string{"y/1", "y/2", "x/1"}))
+	assertT.Equal(1, sm.RemoveIf(func(key string, val int) bool { return true }))
+	assertT.Equal(0, sm.Len())
+}
+
+func TestRefreshRetagsEntry(t *testing.T) {
+	assertT := assert.New(t)
+
+	var loads atomic.Int32
+	em := NewExpiryMap[string, int]().
+		WithTaggedLoader(func(key string) (int, []string, error) {
+			time.Sleep(time.Millisecond)
+			return len(key), []string{"load:" + strconv.Itoa(int(loads.Add(1)))}, nil
+		}).
+		ExpireAfter(time.Hour).
+		WithEarlyRefresh(1e9) // any load time outweighs the hour left
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Refreshed)
+	defer cancel()
+
+	_, _ = em.Get("Hi")
+	_, _ = em.Get("Hi") // triggers refresh
+	<-events
+
+	assertT.Equal([]string{"load:2"}, em.Tags("Hi"))
+	assertT.Equal(0, em.InvalidateTag("load:1"))
+	assertT.True(em.ContainsKey("Hi"))
+	assertT.NotContains(em.tags.keys, "load:1")
+	assertT.Equal(1, em.InvalidateTag("load:2"))
+}
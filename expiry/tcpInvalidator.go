@@ -0,0 +1,309 @@
+package expiry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Max size of an invalidation frame
+	maxFrameSize = 1 << 20
+	// Time limit of writing a frame to a connection
+	frameWriteTimeout = 5 * time.Second
+	// Default delay between attempts to restore connection to the hub
+	DefaultReconnectDelay = 100 * time.Millisecond
+)
+
+// Error returned by `TCPInvalidator.Publish` while connection to the hub is down
+var ErrNotConnected = errors.New("invalidator is not connected")
+
+// Frames are length-prefixed payloads
+func writeFrame(w io.Writer, payload []byte) error {
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit", size)
+	}
+	payload := make([]byte, size)
+	_, err := io.ReadFull(r, payload)
+	return payload, err
+}
+
+// TCP relay that forwards frames received from each connected `TCPInvalidator` to all the others.
+// Frames are not decoded, so one hub serves invalidators of any key type.
+type TCPHub struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    map[net.Conn]*sync.Mutex // connections with their write locks
+	running  sync.WaitGroup
+}
+
+// Starts hub listening on the address, e.g. "localhost:7070" or "127.0.0.1:0" for a random port
+func ListenTCPHub(addr string) (*TCPHub, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	hub := &TCPHub{listener: listener, conns: make(map[net.Conn]*sync.Mutex)}
+	hub.running.Add(1)
+	go hub.accept()
+	return hub, nil
+}
+
+// Returns the address the hub listens on
+func (hub *TCPHub) Addr() string {
+	return hub.listener.Addr().String()
+}
+
+// Returns number of connected invalidators
+func (hub *TCPHub) Clients() int {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return len(hub.conns)
+}
+
+// Stops listening and drops all connections
+func (hub *TCPHub) Close() error {
+	err := hub.listener.Close()
+	hub.lock.Lock()
+	for conn := range hub.conns {
+		conn.Close()
+	}
+	hub.lock.Unlock()
+	hub.running.Wait()
+	return err
+}
+
+func (hub *TCPHub) accept() {
+	defer hub.running.Done()
+	for {
+		conn, err := hub.listener.Accept()
+		if err != nil {
+			return
+		}
+		hub.lock.Lock()
+		hub.conns[conn] = &sync.Mutex{}
+		hub.lock.Unlock()
+		hub.running.Add(1)
+		go hub.serve(conn)
+	}
+}
+
+func (hub *TCPHub) serve(conn net.Conn) {
+	defer hub.running.Done()
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			hub.lock.Lock()
+			delete(hub.conns, conn)
+			hub.lock.Unlock()
+			conn.Close()
+			return
+		}
+		hub.relay(conn, frame)
+	}
+}
+
+func (hub *TCPHub) relay(from net.Conn, frame []byte) {
+	type target struct {
+		conn net.Conn
+		lock *sync.Mutex
+	}
+	hub.lock.Lock()
+	targets := make([]target, 0, len(hub.conns))
+	for conn, lock := range hub.conns {
+		if conn != from {
+			targets = append(targets, target{conn, lock})
+		}
+	}
+	hub.lock.Unlock()
+
+	for _, t := range targets {
+		t.lock.Lock()
+		_ = t.conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+		if writeFrame(t.conn, frame) != nil {
+			t.conn.Close() // its reader drops the connection
+		}
+		t.lock.Unlock()
+	}
+}
+
+// Invalidator that exchanges messages with other processes through `TCPHub`. Keys are encoded with `encoding/gob`.
+// Messages are delivered to local subscribers synchronously and to remote ones on the best effort basis -
+// messages published while connection to the hub is down are lost, hence invalidation should be combined with time-to-live.
+// Lost connection is restored in the background.
+type TCPInvalidator[K comparable] struct {
+	addr      string
+	delay     time.Duration
+	local     *Bus[K]
+	lock      sync.Mutex // guards conn and closed
+	writeLock sync.Mutex
+	conn      net.Conn
+	closed    bool
+	done      chan struct{}
+	running   sync.WaitGroup
+}
+
+// Connects invalidator to the hub at the address
+func DialTCPInvalidator[K comparable](addr string) (*TCPInvalidator[K], error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ti := &TCPInvalidator[K]{
+		addr:  addr,
+		delay: DefaultReconnectDelay,
+		local: NewBus[K](),
+		conn:  conn,
+		done:  make(chan struct{}),
+	}
+	ti.running.Add(1)
+	go ti.receive()
+	return ti, nil
+}
+
+// Modifies delay between attempts to restore connection to the hub
+func (ti *TCPInvalidator[K]) WithReconnectDelay(delay time.Duration) *TCPInvalidator[K] {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	ti.delay = delay
+	return ti
+}
+
+// Returns `true` if the invalidator is connected to the hub
+func (ti *TCPInvalidator[K]) IsConnected() bool {
+	return ti.current() != nil
+}
+
+// Delivers the message to local subscribers and sends it to the hub.
+// Returns `ErrNotConnected` while connection is down, or error of encoding or sending the message.
+func (ti *TCPInvalidator[K]) Publish(msg Invalidation[K]) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&msg); err != nil {
+		return err
+	}
+	_ = ti.local.Publish(msg)
+
+	conn := ti.current()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	ti.writeLock.Lock()
+	defer ti.writeLock.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	err := writeFrame(conn, buf.Bytes())
+	if err != nil {
+		conn.Close() // receiver reconnects
+	}
+	return err
+}
+
+// Registers handler of messages published locally or received from the hub
+func (ti *TCPInvalidator[K]) Subscribe(handler func(msg Invalidation[K])) func() {
+	return ti.local.Subscribe(handler)
+}
+
+// Disconnects from the hub. Subsequent calls have no effect.
+func (ti *TCPInvalidator[K]) Close() error {
+	ti.lock.Lock()
+	if ti.closed {
+		ti.lock.Unlock()
+		return nil
+	}
+	ti.closed = true
+	close(ti.done)
+	if ti.conn != nil {
+		ti.conn.Close()
+	}
+	ti.lock.Unlock()
+	ti.running.Wait()
+	return nil
+}
+
+func (ti *TCPInvalidator[K]) current() net.Conn {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	return ti.conn
+}
+
+// Reads messages from the hub, reconnecting when the connection breaks
+func (ti *TCPInvalidator[K]) receive() {
+	defer ti.running.Done()
+	for {
+		conn := ti.current()
+		if conn == nil {
+			if conn = ti.reconnect(); conn == nil {
+				return
+			}
+		}
+		ti.readAll(conn)
+
+		ti.lock.Lock()
+		conn.Close()
+		ti.conn = nil
+		closed := ti.closed
+		ti.lock.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+func (ti *TCPInvalidator[K]) readAll(conn net.Conn) {
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		var msg Invalidation[K]
+		if gob.NewDecoder(bytes.NewReader(frame)).Decode(&msg) == nil {
+			_ = ti.local.Publish(msg)
+		}
+	}
+}
+
+// Dials the hub until it succeeds or the invalidator is closed; returns `nil` in the latter case
+func (ti *TCPInvalidator[K]) reconnect() net.Conn {
+	for {
+		ti.lock.Lock()
+		delay := ti.delay
+		ti.lock.Unlock()
+		select {
+		case <-ti.done:
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := net.Dial("tcp", ti.addr)
+		if err != nil {
+			continue
+		}
+		ti.lock.Lock()
+		if ti.closed {
+			ti.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		ti.conn = conn
+		ti.lock.Unlock()
+		return conn
+	}
+}
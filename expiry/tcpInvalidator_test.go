@@ -0,0 +1,120 @@
+package expiry
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dialInvalidators(t *testing.T, hub *TCPHub, count int) []*TCPInvalidator[string] {
+	invs := make([]*TCPInvalidator[string], count)
+	for i := range invs {
+		inv, err := DialTCPInvalidator[string](hub.Addr())
+		assert.Nil(t, err)
+		invs[i] = inv.WithReconnectDelay(sleepTime)
+	}
+	assert.Eventually(t, func() bool { return hub.Clients() == count }, waitTime, time.Millisecond)
+	return invs
+}
+
+func TestFrames(t *testing.T) {
+	assertT := assert.New(t)
+
+	var buf bytes.Buffer
+	assertT.Nil(writeFrame(&buf, []byte("Hello")))
+	assertT.Nil(writeFrame(&buf, []byte{}))
+	frame, err := readFrame(&buf)
+	assertT.Nil(err)
+	assertT.Equal("Hello", string(frame))
+	frame, err = readFrame(&buf)
+	assertT.Nil(err)
+	assertT.Empty(frame)
+	_, err = readFrame(&buf)
+	assertT.NotNil(err)
+
+	_, err = readFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assertT.ErrorContains(err, "exceeds limit")
+}
+
+func TestTCPInvalidation(t *testing.T) {
+	assertT := assert.New(t)
+
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	assertT.Nil(err)
+	defer hub.Close()
+	invs := dialInvalidators(t, hub, 3)
+	replicas := make([]*ExpiryMap[string, int], len(invs))
+	for i, inv := range invs {
+		defer inv.Close()
+		replicas[i] = newReplicas(inv, 1)[0]
+		defer replicas[i].Discard()
+	}
+
+	assertT.True(replicas[0].Remove("Hi"))
+	assertT.Eventually(func() bool {
+		return !replicas[1].ContainsKey("Hi") && !replicas[2].ContainsKey("Hi")
+	}, waitTime, time.Millisecond)
+	assertT.True(replicas[1].ContainsKey("Hello"))
+
+	replicas[2].Clear()
+	assertT.Eventually(func() bool {
+		return replicas[0].Len() == 0 && replicas[1].Len() == 0
+	}, waitTime, time.Millisecond)
+}
+
+func TestTCPLocalDelivery(t *testing.T) {
+	assertT := assert.New(t)
+
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	assertT.Nil(err)
+	defer hub.Close()
+	inv := dialInvalidators(t, hub, 1)[0]
+	defer inv.Close()
+
+	replicas := newReplicas(inv, 2)
+	defer discardAll(replicas)
+	replicas[0].Remove("Hi")
+	assertT.False(replicas[1].ContainsKey("Hi"))
+}
+
+func TestTCPReconnect(t *testing.T) {
+	assertT := assert.New(t)
+
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	assertT.Nil(err)
+	addr := hub.Addr()
+	invs := dialInvalidators(t, hub, 2)
+	defer invs[0].Close()
+	defer invs[1].Close()
+
+	hub.Close()
+	assertT.Eventually(func() bool { return !invs[0].IsConnected() }, waitTime, time.Millisecond)
+	assertT.ErrorIs(invs[0].Publish(Invalidation[string]{Key: "Hi"}), ErrNotConnected)
+
+	hub, err = ListenTCPHub(addr)
+	assertT.Nil(err)
+	defer hub.Close()
+	assertT.Eventually(func() bool { return hub.Clients() == 2 }, waitTime, time.Millisecond)
+
+	received := make(chan Invalidation[string], 1)
+	invs[1].Subscribe(func(msg Invalidation[string]) { received <- msg })
+	assertT.Eventually(func() bool { return invs[0].IsConnected() }, waitTime, time.Millisecond)
+	assertT.Nil(invs[0].Publish(Invalidation[string]{Origin: "x", Key: "Hi"}))
+	assertT.Equal(Invalidation[string]{Origin: "x", Key: "Hi"}, <-received)
+}
+
+func TestTCPClose(t *testing.T) {
+	assertT := assert.New(t)
+
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	assertT.Nil(err)
+	defer hub.Close()
+	inv := dialInvalidators(t, hub, 1)[0]
+
+	assertT.Nil(inv.Close())
+	assertT.Nil(inv.Close())
+	assertT.False(inv.IsConnected())
+	assertT.Eventually(func() bool { return hub.Clients() == 0 }, waitTime, time.Millisecond)
+}
@@ -0,0 +1,251 @@
+package expiry
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Secondary (L2) storage of a tiered cache, e.g. on disk or in a remote store
+type SecondaryStore[K comparable, V any] interface {
+	// Returns the value stored for the key; the second value is `false` if the key is not present
+	Get(key K) (V, bool, error)
+	// Stores the value for the key
+	Put(key K, val V) error
+	// Deletes the key; deleting an absent key is not an error
+	Delete(key K) error
+}
+
+// Cumulative statistics of movements between cache tiers
+type TierStats struct {
+	// number of entries moved from L1 to L2 after eviction
+	Demotions uint64
+	// number of L1 misses served from L2
+	Promotions uint64
+	// number of failed secondary store operations
+	StoreErrors uint64
+}
+
+// Two-tier cache with ExpiryMap as L1 and a secondary store as L2. Entries evicted from L1 for capacity reasons are demoted to L2.
+// On L1 miss the value is looked up in L2 and promoted to L1, the origin loader is invoked only if L2 doesn't have it.
+// Tiers are exclusive - an entry promoted to L1 is deleted from L2. Expired entries are not demoted.
+type Tiered[K comparable, V any] struct {
+	*ExpiryMap[K, V]
+	store       SecondaryStore[K, V]
+	origin      func(key K) (V, []string, error)
+	lock        sync.Mutex
+	demoted     map[K][]string // keys demoted to L2 by this cache with their tags
+	demotions   atomic.Uint64
+	promotions  atomic.Uint64
+	storeErrors atomic.Uint64
+}
+
+// Creates tiered cache from the map and the store. The current map loader becomes the origin loader.
+func NewTiered[K comparable, V any](l1 *ExpiryMap[K, V], l2 SecondaryStore[K, V]) *Tiered[K, V] {
+	ret := &Tiered[K, V]{
+		ExpiryMap: l1,
+		store:     l2,
+		origin:    l1.taggedLoader,
+		demoted:   make(map[K][]string),
+	}
+	if ret.origin == nil {
+		ret.WithLoader(l1.loader)
+	}
+	l1.WithTaggedLoader(ret.load)
+	l1.WriteAtomically(func() {
+		l1.demote = ret.demote
+		l1.invalidated = ret.invalidated
+	})
+	return ret
+}
+
+// Modifies origin loader that is invoked when neither of tiers has the key
+func (t *Tiered[K, V]) WithLoader(loader func(key K) (V, error)) *Tiered[K, V] {
+	t.origin = func(key K) (V, []string, error) {
+		val, err := loader(key)
+		return val, nil, err
+	}
+	return t
+}
+
+// Modifies origin loader to the one that also returns tags - see `ExpiryMap.WithTaggedLoader`.
+// Tags of demoted entries are kept, so that `InvalidateTag` removes them from L2 too.
+func (t *Tiered[K, V]) WithTaggedLoader(loader func(key K) (V, []string, error)) *Tiered[K, V] {
+	t.origin = loader
+	return t
+}
+
+// Returns the secondary store
+func (t *Tiered[K, V]) Store() SecondaryStore[K, V] {
+	return t.store
+}
+
+// Returns cumulative statistics of movements between tiers
+func (t *Tiered[K, V]) TierStats() TierStats {
+	return TierStats{
+		Demotions:   t.demotions.Load(),
+		Promotions:  t.promotions.Load(),
+		StoreErrors: t.storeErrors.Load(),
+	}
+}
+
+func (t *Tiered[K, V]) load(key K) (V, []string, error) {
+	val, ok, err := t.store.Get(key)
+	if err != nil {
+		t.storeErrors.Add(1)
+	} else if ok {
+		t.promotions.Add(1)
+		t.checkStore(t.store.Delete(key))
+		t.lock.Lock()
+		tags := t.demoted[key]
+		delete(t.demoted, key)
+		t.lock.Unlock()
+		return val, tags, nil
+	}
+	return t.origin(key)
+}
+
+func (t *Tiered[K, V]) checkStore(err error) {
+	if err != nil {
+		t.storeErrors.Add(1)
+	}
+}
+
+// Moves entry evicted from L1 to ensure capacity to L2. Called with L1 W-lock, so that removals from L1 can't interleave.
+func (t *Tiered[K, V]) demote(key K, val V, tags []string) {
+	t.demotions.Add(1)
+	err := t.store.Put(key, val)
+	t.checkStore(err)
+	if err == nil {
+		t.lock.Lock()
+		t.demoted[key] = tags
+		t.lock.Unlock()
+	}
+}
+
+// Removes the key from both tiers.
+//   - return `true` if value was removed from either tier
+func (t *Tiered[K, V]) Remove(key K) bool {
+	removed := t.ExpiryMap.Remove(key)
+	return t.removeFromStore(key) || removed
+}
+
+// Deletes the key from L2.
+//   - returns `true` if the store had the key
+func (t *Tiered[K, V]) removeFromStore(key K) bool {
+	t.lock.Lock()
+	delete(t.demoted, key)
+	t.lock.Unlock()
+	_, inStore, err := t.store.Get(key)
+	t.checkStore(err)
+	if inStore {
+		t.checkStore(t.store.Delete(key))
+	}
+	return inStore
+}
+
+// Returns keys demoted to L2 by this cache, which tags satisfy the predicate
+func (t *Tiered[K, V]) demotedKeys(pred func(tags []string) bool) []K {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	keys := make([]K, 0)
+	for key, tags := range t.demoted {
+		if pred(tags) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Removes all entries from both tiers. L2 is cleared with its `Clear() error` method, if the store has one,
+// otherwise only the keys demoted by this cache are deleted.
+func (t *Tiered[K, V]) Clear() {
+	t.ExpiryMap.Clear()
+	t.clearStore()
+}
+
+// Purges L2 after invalidation message of another instance has been applied to L1
+func (t *Tiered[K, V]) invalidated(key K, all bool) {
+	if all {
+		t.clearStore()
+	} else {
+		t.removeFromStore(key)
+	}
+}
+
+// Deletes all entries of L2 - see `Clear`
+func (t *Tiered[K, V]) clearStore() {
+	if clearer, ok := t.store.(interface{ Clear() error }); ok {
+		t.checkStore(clearer.Clear())
+		t.lock.Lock()
+		t.demoted = make(map[K][]string)
+		t.lock.Unlock()
+		return
+	}
+	for _, key := range t.demotedKeys(func([]string) bool { return true }) {
+		t.removeFromStore(key)
+	}
+}
+
+// Removes entries of both tiers for which the predicate returns `true` - see `ExpiryMap.RemoveIf`.
+// In L2 only the keys demoted by this cache are checked.
+//   - returns number of removed entries
+func (t *Tiered[K, V]) RemoveIf(pred func(key K, val V) bool) int {
+	count := t.ExpiryMap.RemoveIf(pred)
+	for _, key := range t.demotedKeys(func([]string) bool { return true }) {
+		val, ok, err := t.store.Get(key)
+		t.checkStore(err)
+		if ok && pred(key, val) && t.removeFromStore(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// Removes entries of the keys from both tiers - see `ExpiryMap.InvalidateAll`.
+//   - returns number of removed entries
+func (t *Tiered[K, V]) InvalidateAll(keys []K) int {
+	count := t.ExpiryMap.InvalidateAll(keys)
+	for _, key := range keys {
+		if t.removeFromStore(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// Removes entries which loader returned the tag from both tiers - see `ExpiryMap.InvalidateTag`.
+// In L2 only the keys demoted by this cache are checked.
+//   - returns number of removed entries
+func (t *Tiered[K, V]) InvalidateTag(tag string) int {
+	count := t.ExpiryMap.InvalidateTag(tag)
+	hasTag := func(tags []string) bool {
+		for _, tg := range tags {
+			if tg == tag {
+				return true
+			}
+		}
+		return false
+	}
+	for _, key := range t.demotedKeys(hasTag) {
+		if t.removeFromStore(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// Replaces the value in the tier that holds the key.
+//   - return `true` if value was replaced
+func (t *Tiered[K, V]) Replace(key K, val V) bool {
+	if t.ExpiryMap.Replace(key, val) {
+		return true
+	}
+	_, inStore, err := t.store.Get(key)
+	t.checkStore(err)
+	if inStore {
+		err = t.store.Put(key, val)
+		t.checkStore(err)
+		return err == nil
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package expiry
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// In-memory secondary store
+type memStore[K comparable, V any] struct {
+	lock sync.Mutex
+	m    map[K]V
+	fail bool
+}
+
+func newMemStore[K comparable, V any]() *memStore[K, V] {
+	return &memStore[K, V]{m: make(map[K]V)}
+}
+
+func (ms *memStore[K, V]) Get(key K) (V, bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if ms.fail {
+		var zero V
+		return zero, false, errors.New("store failure")
+	}
+	val, ok := ms.m[key]
+	return val, ok, nil
+}
+
+func (ms *memStore[K, V]) Put(key K, val V) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.m[key] = val
+	return nil
+}
+
+func (ms *memStore[K, V]) Delete(key K) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.m, key)
+	return nil
+}
+
+func TestTiered(t *testing.T) {
+	assertT := assert.New(t)
+
+	loads := make([]string, 0)
+	store := newMemStore[string, int]()
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithLoader(func(key string) (int, error) {
+			loads = append(loads, key)
+			return len(key), nil
+		}), store)
+	defer tc.Discard()
+	assertT.Equal(store, tc.Store())
+
+	v, _ := tc.Get("Hi")
+	assertT.Equal(2, v)
+	v, _ = tc.Get("Hello")
+	assertT.Equal(5, v)
+	assertT.Equal([]string{"Hi", "Hello"}, loads)
+	assertT.Equal(map[string]int{"Hi": 2}, store.m)
+
+	v, _ = tc.Get("Hi")
+	assertT.Equal(2, v)
+	assertT.Equal([]string{"Hi", "Hello"}, loads)
+	assertT.Equal(map[string]int{"Hello": 5}, store.m)
+	assertT.Equal(TierStats{Demotions: 2, Promotions: 1}, tc.TierStats())
+
+	assertT.True(tc.Replace("Hello", 7))
+	assertT.Equal(map[string]int{"Hello": 7}, store.m)
+	assertT.True(tc.Replace("Hi", 3))
+	v, _ = tc.Peek("Hi")
+	assertT.Equal(3, v)
+	assertT.False(tc.Replace("World!", 6))
+
+	assertT.True(tc.Remove("Hello"))
+	assertT.Empty(store.m)
+	assertT.True(tc.Remove("Hi"))
+	assertT.False(tc.Remove("Hi"))
+}
+
+func TestTieredExpiredNotDemoted(t *testing.T) {
+	assertT := assert.New(t)
+
+	store := newMemStore[string, int]()
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().
+		ExpireAfter(sleepTime), store).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	defer tc.Discard()
+
+	_, _ = tc.Get("Hi")
+	assertT.Eventually(func() bool { return tc.Len() == 0 }, waitTime, sleepTime)
+	assertT.Empty(store.m)
+	assertT.Equal(uint64(0), tc.TierStats().Demotions)
+}
+
+func TestTieredStoreFailure(t *testing.T) {
+	assertT := assert.New(t)
+
+	store := newMemStore[string, int]()
+	store.fail = true
+	tc := NewTiered[string, int](NewExpiryMap[string, int](), store).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	defer tc.Discard()
+
+	v, err := tc.Get("Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+	assertT.Equal(uint64(1), tc.TierStats().StoreErrors)
+}
+
+func TestTieredWithFileStore(t *testing.T) {
+	assertT := assert.New(t)
+
+	origin := 0
+	store, _ := NewFileStore[string, int](t.TempDir())
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().
+		WithMaxCapacity(2).
+		WithLoader(func(key string) (int, error) {
+			origin++
+			return len(key), nil
+		}), store)
+	defer tc.Discard()
+
+	for _, key := range []string{"a", "bb", "ccc", "dddd"} {
+		_, _ = tc.Get(key)
+	}
+	n, _ := store.Len()
+	assertT.Equal(2, n)
+
+	v, _ := tc.Get("a")
+	assertT.Equal(1, v)
+	assertT.Equal(uint64(1), tc.TierStats().Promotions)
+	assertT.Equal(4, origin)
+	assertT.Equal(uint64(5), tc.Stats().Loads)
+
+	tc.Clear()
+	n, _ = store.Len()
+	assertT.Equal(0, n)
+	assertT.Equal(0, tc.Len())
+}
+
+func TestTieredDemotionNotDropped(t *testing.T) {
+	assertT := assert.New(t)
+
+	store := newMemStore[string, int]()
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithAsyncListeners(1, DropNewest).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) { time.Sleep(time.Millisecond) }}), store).
+		WithLoader(func(key string) (int, error) { return len(key), nil })
+	defer tc.Discard()
+
+	for i := 0; i < 20; i++ {
+		_, _ = tc.Get("k" + strconv.Itoa(i))
+	}
+	assertT.Equal(19, len(store.m)) // demoted before Get returns, regardless of event delivery
+	assertT.Equal(uint64(19), tc.TierStats().Demotions)
+}
+
+func TestTieredBulkRemoval(t *testing.T) {
+	assertT := assert.New(t)
+
+	store := newMemStore[string, int]()
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().WithMaxCapacity(2), store).
+		WithTaggedLoader(tenantLoader)
+	defer tc.Discard()
+	load := func(keys ...string) {
+		for _, key := range keys {
+			_, _ = tc.Get(key)
+		}
+	}
+
+	load("x/1", "x/22", "y/1", "y/22") // "x/1" and "x/22" are demoted
+	assertT.Equal(2, len(store.m))
+	assertT.Equal(2, tc.InvalidateTag("tenant:x"))
+	assertT.Empty(store.m)
+	assertT.Equal([]string{"y/1", "y/22"}, tc.Keys())
+
+	load("x/1", "x/22") // "y/1" and "y/22" are demoted
+	assertT.Equal(2, tc.RemoveIf(func(key string, val int) bool { return val == 4 }))
+	assertT.Equal(map[string]int{"y/1": 3}, store.m)
+	assertT.Equal([]string{"x/1"}, tc.Keys())
+
+	assertT.Equal(2, tc.InvalidateAll([]string{"x/1", "y/1", "z/1"}))
+	assertT.Empty(store.m)
+	assertT.Equal(0, tc.Len())
+
+	load("a/1", "b/1", "c/1")
+	tc.Clear()
+	assertT.Empty(store.m)
+	assertT.Equal(0, tc.Len())
+}
+
+func TestTieredPromotionKeepsTags(t *testing.T) {
+	assertT := assert.New(t)
+
+	store := newMemStore[string, int]()
+	tc := NewTiered[string, int](NewExpiryMap[string, int]().WithMaxCapacity(1).WithTaggedLoader(tenantLoader), store)
+	defer tc.Discard()
+
+	_, _ = tc.Get("x/1")
+	_, _ = tc.Get("y/1")
+	_, _ = tc.Get("x/1") // promoted from L2
+	assertT.Equal([]string{"tenant:x"}, tc.Tags("x/1"))
+	assertT.Equal(1, tc.InvalidateTag("tenant:y"))
+	assertT.Equal(1, tc.InvalidateTag("tenant:x"))
+}
+
+func TestTieredRemoteInvalidation(t *testing.T) {
+	assertT := assert.New(t)
+
+	bus := NewBus[string]()
+	newReplica := func(store *memStore[string, int]) *Tiered[string, int] {
+		return NewTiered[string, int](NewExpiryMap[string, int]().
+			WithMaxCapacity(1).
+			WithLoader(func(key string) (int, error) { return len(key), nil }).
+			WithInvalidator(bus), store)
+	}
+	store1, store2 := newMemStore[string, int](), newMemStore[string, int]()
+	tc1, tc2 := newReplica(store1), newReplica(store2)
+	defer tc1.Discard()
+	defer tc2.Discard()
+
+	_, _ = tc2.Get("Hi")
+	_, _ = tc2.Get("Hello")
+	assertT.Equal(map[string]int{"Hi": 2}, store2.m)
+
+	// the remote removal doesn't let L2 promote the value again
+	assertT.False(tc1.Remove("Hi"))
+	assertT.Empty(store2.m)
+	_, _ = tc2.Get("Hi")
+	assertT.Equal(uint64(0), tc2.TierStats().Promotions)
+
+	assertT.Equal(map[string]int{"Hello": 5}, store2.m)
+	tc1.Clear()
+	assertT.Empty(store2.m)
+	assertT.Equal(0, tc2.Len())
+}
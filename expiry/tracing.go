@@ -0,0 +1,137 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Traced map operation
+type Operation int
+
+const (
+	// loading of a missing value
+	OpLoad Operation = iota
+	// removal of an entry after its time-to-live or to ensure capacity
+	OpEvict
+	// early reloading of an entry before its expiry
+	OpRefresh
+)
+
+// Result of a traced operation
+type Outcome int
+
+const (
+	// operation succeeded
+	OutcomeSuccess Outcome = iota
+	// loader failed
+	OutcomeFailure
+	// loader failed and a stale value was served
+	OutcomeStale
+	// load was rejected by open circuit breaker without calling the loader
+	OutcomeRejected
+)
+
+// Hook that reports map operations to a tracing system. Implementations are expected to adapt it to their
+// tracing library, e.g. by starting a child span of the one found in the context.
+type Tracer interface {
+	// Starts a span for the operation on the key. The returned context carries the span.
+	Start(ctx context.Context, op Operation, key any) (context.Context, Span)
+}
+
+// Span started by `Tracer`
+type Span interface {
+	// Ends the span with the outcome and the duration of the operation; `err` is not `nil` for failed operations
+	End(outcome Outcome, duration time.Duration, err error)
+}
+
+// Tracer that does nothing. Used by default.
+type NoopTracer struct{}
+
+func (NoopTracer) Start(ctx context.Context, op Operation, key any) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(outcome Outcome, duration time.Duration, err error) {}
+
+// Finished span captured by `RecordingTracer`
+type SpanRecord struct {
+	// traced operation
+	Op Operation
+	// key of the operation
+	Key any
+	// result of the operation
+	Outcome Outcome
+	// duration of the operation
+	Duration time.Duration
+	// error of failed operation
+	Err error
+	// context the span was started with
+	Context context.Context
+}
+
+// Tracer that keeps finished spans in memory. Intended for tests.
+type RecordingTracer struct {
+	lock  sync.Mutex
+	spans []SpanRecord
+}
+
+// Creates recording tracer without spans
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (rt *RecordingTracer) Start(ctx context.Context, op Operation, key any) (context.Context, Span) {
+	return ctx, &recordingSpan{tracer: rt, record: SpanRecord{Op: op, Key: key, Context: ctx}}
+}
+
+// Returns copy of finished spans in the order they ended
+func (rt *RecordingTracer) Spans() []SpanRecord {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]SpanRecord{}, rt.spans...)
+}
+
+// Drops finished spans
+func (rt *RecordingTracer) Reset() {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.spans = nil
+}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	record SpanRecord
+}
+
+func (rs *recordingSpan) End(outcome Outcome, duration time.Duration, err error) {
+	rs.record.Outcome, rs.record.Duration, rs.record.Err = outcome, duration, err
+	rs.tracer.lock.Lock()
+	defer rs.tracer.lock.Unlock()
+	rs.tracer.spans = append(rs.tracer.spans, rs.record)
+}
+
+func loadOutcome(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrStale):
+		return OutcomeStale
+	case errors.Is(err, ErrCircuitOpen):
+		return OutcomeRejected
+	default:
+		return OutcomeFailure
+	}
+}
+
+// Modifies tracer that reports loads, refreshes and evictions. `nil` restores the default `NoopTracer`.
+func (em *ExpiryMap[K, V]) WithTracer(tracer Tracer) *ExpiryMap[K, V] {
+	if tracer == nil {
+		tracer = NoopTracer{}
+	}
+	em.tracer = tracer
+	return em
+}
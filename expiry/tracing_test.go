@@ -0,0 +1,113 @@
+package expiry
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestTraceLoads(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	tracer := NewRecordingTracer()
+	em := newFlakyMap(&failing).
+		ExpireAfter(Eternity).
+		WithTracer(tracer)
+	defer em.Discard()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "trace-1")
+	v, err := em.GetCtx(ctx, "Hi")
+	assertT.Nil(err)
+	assertT.Equal(2, v)
+	_, _ = em.Get("Hi") // hit is not traced
+
+	failing.Store(true)
+	_, err = em.Get("Hello")
+	assertT.ErrorIs(err, errOrigin)
+
+	spans := tracer.Spans()
+	assertT.Len(spans, 2)
+	assertT.Equal(OpLoad, spans[0].Op)
+	assertT.Equal("Hi", spans[0].Key)
+	assertT.Equal(OutcomeSuccess, spans[0].Outcome)
+	assertT.Nil(spans[0].Err)
+	assertT.Greater(spans[0].Duration, time.Duration(0))
+	assertT.Equal("trace-1", spans[0].Context.Value(ctxKey{}))
+
+	assertT.Equal("Hello", spans[1].Key)
+	assertT.Equal(OutcomeFailure, spans[1].Outcome)
+	assertT.ErrorIs(spans[1].Err, errOrigin)
+	assertT.Nil(spans[1].Context.Value(ctxKey{}))
+
+	tracer.Reset()
+	assertT.Empty(tracer.Spans())
+}
+
+func TestTraceStaleAndRejected(t *testing.T) {
+	assertT := assert.New(t)
+
+	var failing atomic.Bool
+	tracer := NewRecordingTracer()
+	em := newFlakyMap(&failing).
+		StaleIfError(time.Hour).
+		WithCircuitBreaker(1, time.Hour).
+		WithTracer(tracer)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	assertT.Eventually(func() bool { return em.Len() == 0 }, waitTime, sleepTime)
+	failing.Store(true)
+	_, err := em.Get("Hi")
+	assertT.ErrorIs(err, ErrStale)
+	_, err = em.Get("Hello")
+	assertT.ErrorIs(err, ErrCircuitOpen)
+
+	spans := tracer.Spans()
+	assertT.Len(spans, 4)
+	assertT.Equal([]Operation{OpLoad, OpEvict, OpLoad, OpLoad}, []Operation{spans[0].Op, spans[1].Op, spans[2].Op, spans[3].Op})
+	assertT.Equal(OutcomeSuccess, spans[1].Outcome)
+	assertT.Equal(OutcomeStale, spans[2].Outcome)
+	assertT.Equal(OutcomeRejected, spans[3].Outcome)
+}
+
+func TestTraceCapacityEviction(t *testing.T) {
+	assertT := assert.New(t)
+
+	tracer := NewRecordingTracer()
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithTracer(tracer)
+	defer em.Discard()
+
+	_, _ = em.Get("Hi")
+	ctx := context.WithValue(context.Background(), ctxKey{}, "trace-2")
+	_, _ = em.GetCtx(ctx, "Hello")
+
+	spans := tracer.Spans()
+	assertT.Len(spans, 3)
+	assertT.Equal(OpEvict, spans[1].Op)
+	assertT.Equal("Hi", spans[1].Key)
+	assertT.Equal("trace-2", spans[1].Context.Value(ctxKey{}))
+	assertT.Equal(OpLoad, spans[2].Op)
+	assertT.Equal("Hello", spans[2].Key)
+}
+
+func TestNoopTracer(t *testing.T) {
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) { return len(key), nil }).
+		WithTracer(NewRecordingTracer()).
+		WithTracer(nil)
+	defer em.Discard()
+
+	assert.Equal(t, NoopTracer{}, em.tracer)
+	v, err := em.GetCtx(context.Background(), "Hi")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+}
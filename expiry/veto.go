@@ -0,0 +1,65 @@
+package expiry
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultMaxVetoes = 3
+	defaultVetoDelay = time.Second
+)
+
+// Sets a hook that is asked before an entry expires or is evicted to ensure capacity. Returning `false` vetoes the removal,
+// e.g. while the value is still in use. A vetoed expiry is retried after a delay, while a vetoed eviction tries the next oldest entry.
+// Once the allowed number of vetoes is exhausted, the expiring entry or the next eviction candidate is removed without asking
+// the hook - see `WithEvictionRetries`.
+// Explicit removals are not subject to the hook. Vetoes raise `Vetoed` events.
+//
+// The hook is called while the map is locked for writing, hence it must be fast and must not call back into the map.
+// `nil` removes the hook.
+func (em *ExpiryMap[K, V]) BeforeEvict(hook func(key K, val V, cause Cause) bool) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.beforeEvict = hook
+	})
+	return em
+}
+
+// Bounds vetoes of `BeforeEvict` hook, so that capacity and time-to-live are still enforced.
+//   - maxVetoes - number of vetoes per expiring entry or per eviction to ensure capacity, 3 by default
+//   - delay - period after which a vetoed expiry is retried, 1 second by default
+func (em *ExpiryMap[K, V]) WithEvictionRetries(maxVetoes int, delay time.Duration) *ExpiryMap[K, V] {
+	em.WriteAtomically(func() {
+		em.maxVetoes = maxVetoes
+		em.vetoDelay = delay
+	})
+	return em
+}
+
+// Asks `BeforeEvict` hook whether the entry may be removed. Must be called with W-lock.
+func (em *ExpiryMap[K, V]) allowEviction(key K, ent entry[V], cause Cause) bool {
+	if em.beforeEvict == nil || em.beforeEvict(key, ent.val, cause) {
+		return true
+	}
+	em.stats.vetoes.Add(1)
+	em.postEntryEvent(Event[K, V]{Type: Vetoed, Key: key, Value: ent.val, Cause: cause}, ent)
+	return false
+}
+
+// Removes the expired entry unless `BeforeEvict` hook vetoes it, in which case the expiry is rescheduled.
+// Must be called with W-lock.
+//   - returns `true` if the entry was removed
+func (em *ExpiryMap[K, V]) expire(ctx context.Context, key K) bool {
+	ent, ok := em.backMap.Get(key)
+	if !ok {
+		return false
+	}
+	if ent.vetoes < em.maxVetoes && !em.allowEviction(key, ent, CauseExpired) {
+		ent.vetoes++
+		em.scheduleAt(key, &ent, time.Now().Add(em.vetoDelay))
+		em.backMap.Put(key, ent)
+		return false
+	}
+	em.evict(ctx, key, CauseExpired)
+	return true
+}
@@ -0,0 +1,90 @@
+package expiry
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVetoEviction(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().
+		WithMaxCapacity(3).
+		BeforeEvict(func(key int, val int, cause Cause) bool { return key != 0 })
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Vetoed)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		_ = em.Put(i, i)
+	}
+	assertT.Equal([]int{0, 3, 4}, em.Keys())
+	assertT.Equal(uint64(2), em.Stats().Vetoes)
+
+	ev := <-events
+	assertT.Equal(0, ev.Key)
+	assertT.Equal(CauseCapacity, ev.Cause)
+}
+
+func TestVetoEvictionBounded(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewExpiryMap[int, int]().
+		WithMaxCapacity(3).
+		BeforeEvict(func(key int, val int, cause Cause) bool { return false }).
+		WithEvictionRetries(2, time.Second)
+	defer em.Discard()
+
+	for i := 0; i < 5; i++ {
+		_ = em.Put(i, i)
+	}
+	assertT.Equal([]int{0, 1, 4}, em.Keys()) // third candidate is evicted without asking
+	assertT.Equal(uint64(4), em.Stats().Vetoes)
+
+	em.BeforeEvict(nil)
+	_ = em.Put(5, 5)
+	assertT.Equal([]int{1, 4, 5}, em.Keys())
+}
+
+func TestVetoExpiry(t *testing.T) {
+	assertT := assert.New(t)
+
+	var busy atomic.Bool
+	busy.Store(true)
+	em := NewExpiryMap[string, int]().
+		ExpireAfter(sleepTime).
+		BeforeEvict(func(key string, val int, cause Cause) bool { return !busy.Load() }).
+		WithEvictionRetries(100, sleepTime)
+	defer em.Discard()
+
+	_ = em.Put("Hi", 1)
+	time.Sleep(4 * sleepTime)
+	assertT.True(em.ContainsKey("Hi"))
+	assertT.Greater(em.Stats().Vetoes, uint64(1))
+
+	busy.Store(false)
+	assertT.Eventually(func() bool { return !em.ContainsKey("Hi") }, waitTime, sleepTime)
+	assertT.Equal(uint64(1), em.Stats().Expirations)
+}
+
+func TestVetoExpiryBounded(t *testing.T) {
+	assertT := assert.New(t)
+
+	em := NewPassiveExpiryMap[string, int]().
+		ExpireAfter(sleepTime).
+		BeforeEvict(func(key string, val int, cause Cause) bool { return false }).
+		WithEvictionRetries(1, sleepTime)
+	defer em.Discard()
+
+	_ = em.Put("Hi", 1)
+	time.Sleep(2 * sleepTime)
+	assertT.Equal(0, em.Cleanup())
+	assertT.True(em.ContainsKey("Hi")) // rescheduled
+
+	time.Sleep(2 * sleepTime)
+	assertT.Equal(1, em.Cleanup())
+	assertT.Equal(uint64(1), em.Stats().Vetoes)
+}
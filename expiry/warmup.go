@@ -0,0 +1,178 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State of the warm-up started with `WithWarmup`
+type warmup struct {
+	done chan struct{}
+	err  error // valid after `done` is closed
+}
+
+// Limits number of loaders invoked in parallel by `Preload`. Values less than one are treated as one.
+func (em *ExpiryMap[K, V]) WithPreloadConcurrency(n int) *ExpiryMap[K, V] {
+	if n < 1 {
+		n = 1
+	}
+	em.preloadLimit = n
+	return em
+}
+
+// Runs `load` once on a background goroutine and seeds the map with the entries it returns, as `PutAll` does.
+// Its context is cancelled when the map is closed. Each seeded entry raises `Added` event and completion raises `WarmedUp` event,
+// which carries the warm-up error, if any, and its duration. Use `AwaitWarmup` or `IsWarm` to learn when the map is populated.
+func (em *ExpiryMap[K, V]) WithWarmup(load func(ctx context.Context) (map[K]V, error)) *ExpiryMap[K, V] {
+	em.warm = startWarmup(em, load, em.PutAll)
+	return em
+}
+
+// Runs `load` and `seed` on a background goroutine, cancelling context of `load` when `em` is closed.
+// Completion is reported with `WarmedUp` event of `em`.
+func startWarmup[K comparable, V any](em *ExpiryMap[K, V], load func(ctx context.Context) (map[K]V, error), seed func(map[K]V) error) *warmup {
+	w := &warmup{done: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-em.stopChan:
+		case <-w.done:
+		}
+		cancel()
+	}()
+
+	go func() {
+		start := time.Now()
+		entries, err := load(ctx)
+		if err == nil {
+			err = seed(entries)
+		}
+		em.dispatcher.post(Event[K, V]{Type: WarmedUp, Err: err, Duration: time.Since(start)})
+		em.flushEvents()
+		w.err = err
+		close(w.done)
+	}()
+	return w
+}
+
+// Waits for completion of the warm-up set with `WithWarmup` and returns its error.
+// Returns the context error if the context is done first, and `nil` immediately if there is no warm-up.
+func (em *ExpiryMap[K, V]) AwaitWarmup(ctx context.Context) error {
+	return em.warm.await(ctx)
+}
+
+// Returns `true` if the warm-up set with `WithWarmup` has completed successfully or if there is no warm-up.
+// Suits readiness probes - the map reports not being warm after a failed warm-up.
+func (em *ExpiryMap[K, V]) IsWarm() bool {
+	return em.warm.isWarm()
+}
+
+func (w *warmup) await(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *warmup) isWarm() bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case <-w.done:
+		return w.err == nil
+	default:
+		return false
+	}
+}
+
+// Seeds the map with the entries, for example, from an existing source, under a single lock. A new entry gets full time-to-live,
+// while an existing one gets the new value without changing its expiry time. Unlike `Put`, the entries are propagated
+// neither to the writer nor to other instances. If the entries exceed map capacity, the oldest ones are evicted as usual.
+//   - returns `ErrClosed` if the map has been closed
+func (em *ExpiryMap[K, V]) PutAll(entries map[K]V) error {
+	var err error
+	em.WriteAtomically(func() {
+		if em.IsClosed() {
+			err = ErrClosed
+			return
+		}
+		for key, val := range entries {
+			em.purgeExpired(context.Background(), key)
+			em.stale.remove(key)
+			em.putEntry(context.Background(), key, val, 0, false)
+		}
+	})
+	em.flushEvents()
+	return err
+}
+
+// Loads values of the keys that are not in the map yet. Unlike `Get`, loaders run without holding the map lock,
+// up to `WithPreloadConcurrency` of them in parallel, hence the loader must be safe for concurrent use.
+// Stale values are not served.
+//   - returns when all keys are processed with errors of failed loads joined, or `ErrClosed` if the map has been closed
+func (em *ExpiryMap[K, V]) Preload(keys []K) error {
+	if em.IsClosed() {
+		return ErrClosed
+	}
+
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, em.preloadLimit)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, key K) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if !em.ContainsKey(key) {
+				errs[i] = em.preload(key)
+			}
+		}(i, key)
+	}
+	wg.Wait()
+	em.flushEvents()
+
+	if em.IsClosed() {
+		return ErrClosed
+	}
+	return errors.Join(errs...)
+}
+
+// Loads the value without holding the lock and adds the entry, unless it has been added meanwhile
+func (em *ExpiryMap[K, V]) preload(key K) error {
+	start := time.Now()
+	spanCtx, span := em.tracer.Start(context.Background(), OpLoad, key)
+	val, tags, err := em.invokeLoader(key)
+	elapsed := time.Since(start)
+	em.stats.recordLoad(elapsed, err)
+	defer span.End(loadOutcome(err), elapsed, err)
+
+	if err != nil {
+		em.dispatcher.post(Event[K, V]{Type: Failed, Key: key, Value: val, Err: err, Duration: elapsed})
+		return err
+	}
+	em.WriteAtomically(func() {
+		if em.IsClosed() {
+			return
+		}
+		em.purgeExpired(spanCtx, key)
+		if _, ok := em.backMap.Get(key); ok {
+			return
+		}
+		em.stale.remove(key)
+		em.addEntry(spanCtx, key, val, elapsed, false)
+		em.tagEntry(key, tags)
+	})
+	return nil
+}
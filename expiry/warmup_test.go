@@ -0,0 +1,148 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreload(t *testing.T) {
+	assertT := assert.New(t)
+
+	var running, maxRunning atomic.Int32
+	em := NewExpiryMap[string, int]().
+		WithLoader(func(key string) (int, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			if key == "fail" {
+				return 0, errOrigin
+			}
+			return len(key), nil
+		}).
+		WithPreloadConcurrency(3)
+	defer em.Discard()
+	_ = em.Put("Hi", 42)
+
+	err := em.Preload([]string{"Hi", "a", "bb", "ccc", "dddd", "eeeee", "fail"})
+	assertT.ErrorIs(err, errOrigin)
+	assertT.Equal(6, em.Len())
+	assertT.False(em.ContainsKey("fail"))
+	assertT.LessOrEqual(maxRunning.Load(), int32(3))
+	assertT.Greater(maxRunning.Load(), int32(1))
+
+	v, _ := em.Peek("Hi")
+	assertT.Equal(42, v)
+	v, _ = em.Peek("ccc")
+	assertT.Equal(3, v)
+
+	stats := em.Stats()
+	assertT.Equal(uint64(5), stats.Loads)
+	assertT.Equal(uint64(1), stats.LoadFailures)
+}
+
+func TestPreloadClosed(t *testing.T) {
+	em := NewExpiryMap[string, int]()
+	em.Discard()
+
+	assert.ErrorIs(t, em.Preload([]string{"Hi"}), ErrClosed)
+}
+
+func TestPutAll(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(3).
+		WithWriteThrough(writer)
+	defer em.Discard()
+	_ = em.Put("Hi", 1)
+	events, cancel := em.Subscribe(10, Added, Replaced)
+	defer cancel()
+
+	assertT.Nil(em.PutAll(map[string]int{"Hi": 2, "Hello": 5}))
+	assertT.Equal(2, em.Len())
+	v, _ := em.Peek("Hi")
+	assertT.Equal(2, v)
+	ops, _ := writer.snapshot()
+	assertT.Equal([]string{"W:Hi"}, ops)
+	assertT.Equal(2, len(events))
+
+	assertT.Nil(em.PutAll(map[string]int{"a": 1, "b": 2}))
+	assertT.Equal(3, em.Len())
+	assertT.False(em.ContainsKey("Hi"))
+
+	em.Discard()
+	assertT.ErrorIs(em.PutAll(map[string]int{"c": 3}), ErrClosed)
+}
+
+func TestWarmup(t *testing.T) {
+	assertT := assert.New(t)
+
+	release := make(chan struct{})
+	em := NewExpiryMap[string, int]().
+		WithWarmup(func(ctx context.Context) (map[string]int, error) {
+			<-release
+			return map[string]int{"Hi": 2, "Hello": 5}, nil
+		})
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, Added, WarmedUp)
+	defer cancel()
+
+	assertT.False(em.IsWarm())
+	ctx, cancelCtx := context.WithTimeout(context.Background(), sleepTime)
+	defer cancelCtx()
+	assertT.ErrorIs(em.AwaitWarmup(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assertT.Nil(em.AwaitWarmup(context.Background()))
+	assertT.True(em.IsWarm())
+	assertT.Equal(2, em.Len())
+
+	assertT.Equal(Added, (<-events).Type)
+	assertT.Equal(Added, (<-events).Type)
+	ev := <-events
+	assertT.Equal(WarmedUp, ev.Type)
+	assertT.Nil(ev.Err)
+	assertT.Greater(ev.Duration, time.Duration(0))
+}
+
+func TestWarmupFailure(t *testing.T) {
+	assertT := assert.New(t)
+
+	errWarmup := errors.New("source is down")
+	em := NewExpiryMap[string, int]().
+		WithWarmup(func(ctx context.Context) (map[string]int, error) {
+			return nil, errWarmup
+		})
+	defer em.Discard()
+
+	assertT.ErrorIs(em.AwaitWarmup(context.Background()), errWarmup)
+	assertT.False(em.IsWarm())
+	assertT.Equal(0, em.Len())
+}
+
+func TestWarmupCancelledOnClose(t *testing.T) {
+	em := NewExpiryMap[string, int]().
+		WithWarmup(func(ctx context.Context) (map[string]int, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	em.Discard()
+
+	assert.ErrorIs(t, em.AwaitWarmup(context.Background()), context.Canceled)
+}
+
+func TestNoWarmup(t *testing.T) {
+	em := NewExpiryMap[string, int]()
+	defer em.Discard()
+
+	assert.True(t, em.IsWarm())
+	assert.Nil(t, em.AwaitWarmup(context.Background()))
+}
@@ -0,0 +1,185 @@
+package expiry
+
+import (
+	"sync"
+	"time"
+)
+
+// Persistence layer behind the map that receives changes made with `Put`, `Replace` and `Remove`
+type Writer[K comparable, V any] interface {
+	// Writes the value for the key
+	Write(key K, val V) error
+	// Deletes the key
+	Delete(key K) error
+}
+
+// Write operation pending in write-behind queue
+type pendingWrite[V any] struct {
+	val      V
+	delete   bool
+	attempts int
+	seq      uint64 // position in the queue
+}
+
+// Queue position of a key, stale if the key was written again since
+type queuedKey[K comparable] struct {
+	key K
+	seq uint64
+}
+
+// Coalesces writes per key and flushes them in batches on a background goroutine.
+// Failed writes are retried on subsequent flushes, unless superseded by a newer write for the same key.
+type writeBehind[K comparable, V any] struct {
+	lock       sync.Mutex
+	flushLock  sync.Mutex // serializes flushes
+	pending    map[K]pendingWrite[V]
+	order      []queuedKey[K] // keys in order of writes, including stale positions
+	lastSeq    uint64
+	writer     Writer[K, V]
+	batchSize  int
+	maxRetries int
+	onFailure  func(key K, val V, err error)
+	kick       chan struct{}
+	done       chan struct{}
+	stopped    sync.WaitGroup
+}
+
+func newWriteBehind[K comparable, V any](writer Writer[K, V], interval time.Duration, batchSize int, maxRetries int,
+	onFailure func(key K, val V, err error)) *writeBehind[K, V] {
+	wb := &writeBehind[K, V]{
+		pending:    make(map[K]pendingWrite[V]),
+		writer:     writer,
+		batchSize:  batchSize,
+		maxRetries: maxRetries,
+		onFailure:  onFailure,
+		kick:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	wb.stopped.Add(1)
+	go func() {
+		defer wb.stopped.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				wb.flush(false, false)
+			case <-wb.kick:
+				wb.flush(false, false)
+			case <-wb.done:
+				return
+			}
+		}
+	}()
+	return wb
+}
+
+// Queues the operation replacing a pending one for the same key
+func (wb *writeBehind[K, V]) enqueue(key K, val V, delete bool) {
+	wb.lock.Lock()
+	wb.push(key, pendingWrite[V]{val: val, delete: delete})
+	full := wb.batchSize > 0 && len(wb.pending) >= wb.batchSize
+	wb.lock.Unlock()
+
+	if full {
+		select {
+		case wb.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Puts the operation at the end of the queue. Must be called with the lock.
+func (wb *writeBehind[K, V]) push(key K, op pendingWrite[V]) {
+	wb.lastSeq++
+	op.seq = wb.lastSeq
+	wb.pending[key] = op
+	wb.order = append(wb.order, queuedKey[K]{key, op.seq})
+
+	// drop stale positions of keys written repeatedly
+	if len(wb.order) > 2*len(wb.pending)+16 {
+		order := make([]queuedKey[K], 0, 2*len(wb.pending))
+		for _, qk := range wb.order {
+			if wb.pending[qk.key].seq == qk.seq {
+				order = append(order, qk)
+			}
+		}
+		wb.order = order
+	}
+}
+
+// Returns number of pending operations
+func (wb *writeBehind[K, V]) size() int {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	return len(wb.pending)
+}
+
+// Writes pending operations - a single batch, or everything if `all` is set.
+// Failed operations are queued for retry, unless it is the final flush.
+func (wb *writeBehind[K, V]) flush(all bool, final bool) {
+	wb.flushLock.Lock()
+	defer wb.flushLock.Unlock()
+
+	keys, ops := wb.takeBatch(all)
+	for i, key := range keys {
+		if err := wb.write(key, ops[i]); err != nil {
+			wb.retry(key, ops[i], err, final)
+		}
+	}
+}
+
+func (wb *writeBehind[K, V]) takeBatch(all bool) ([]K, []pendingWrite[V]) {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	n := len(wb.pending)
+	if !all && wb.batchSize > 0 && n > wb.batchSize {
+		n = wb.batchSize
+	}
+	keys := make([]K, 0, n)
+	ops := make([]pendingWrite[V], 0, n)
+	for len(keys) < n {
+		qk := wb.order[0]
+		wb.order = wb.order[1:]
+		if op, ok := wb.pending[qk.key]; ok && op.seq == qk.seq {
+			keys = append(keys, qk.key)
+			ops = append(ops, op)
+			delete(wb.pending, qk.key)
+		}
+	}
+	if len(wb.pending) == 0 {
+		wb.order = nil
+	}
+	return keys, ops
+}
+
+func (wb *writeBehind[K, V]) write(key K, op pendingWrite[V]) error {
+	if op.delete {
+		return wb.writer.Delete(key)
+	}
+	return wb.writer.Write(key, op.val)
+}
+
+func (wb *writeBehind[K, V]) retry(key K, op pendingWrite[V], err error, final bool) {
+	op.attempts++
+	wb.lock.Lock()
+	_, superseded := wb.pending[key]
+	requeue := !superseded && !final && op.attempts <= wb.maxRetries
+	if requeue {
+		wb.push(key, op)
+	}
+	wb.lock.Unlock()
+
+	if !requeue && !superseded {
+		wb.onFailure(key, op.val, err)
+	}
+}
+
+// Flushes all pending operations and stops the background goroutine
+func (wb *writeBehind[K, V]) stop() {
+	close(wb.done)
+	wb.stopped.Wait()
+	wb.flush(true, true)
+}
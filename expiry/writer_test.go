@@ -0,0 +1,271 @@
+package expiry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writer that records operations and fails on request
+type recordingWriter struct {
+	lock     sync.Mutex
+	ops      []string
+	data     map[string]int
+	failures int
+}
+
+func newRecordingWriter() *recordingWriter {
+	return &recordingWriter{ops: make([]string, 0), data: make(map[string]int)}
+}
+
+func (rw *recordingWriter) fail() error {
+	if rw.failures > 0 {
+		rw.failures--
+		return errors.New("write failure")
+	}
+	return nil
+}
+
+func (rw *recordingWriter) Write(key string, val int) error {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	if err := rw.fail(); err != nil {
+		return err
+	}
+	rw.ops = append(rw.ops, "W:"+key)
+	rw.data[key] = val
+	return nil
+}
+
+func (rw *recordingWriter) Delete(key string) error {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	if err := rw.fail(); err != nil {
+		return err
+	}
+	rw.ops = append(rw.ops, "D:"+key)
+	delete(rw.data, key)
+	return nil
+}
+
+func (rw *recordingWriter) setFailures(n int) {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	rw.failures = n
+}
+
+func (rw *recordingWriter) snapshot() ([]string, map[string]int) {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	data := make(map[string]int)
+	for k, v := range rw.data {
+		data[k] = v
+	}
+	return append([]string{}, rw.ops...), data
+}
+
+func TestPut(t *testing.T) {
+	assertT := assert.New(t)
+
+	events := make([]EventType, 0)
+	em := NewExpiryMap[string, int]().
+		WithMaxCapacity(1).
+		AddListener(&ListenerWarapper{func(ev EventType, key string, val int, err error) { events = append(events, ev) }})
+
+	assertT.Nil(em.Put("Hi", 2))
+	assertT.Nil(em.Put("Hi", 3))
+	v, _ := em.Get("Hi")
+	assertT.Equal(3, v)
+	assertT.Nil(em.Put("Hello", 5))
+	assertT.Equal([]string{"Hello"}, em.Keys())
+	assertT.Equal([]EventType{Added, Replaced, Requested, Removed, Added}, events)
+
+	em.Discard()
+	assertT.ErrorIs(em.Put("Hi", 2), ErrClosed)
+}
+
+func TestWriteThrough(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteThrough(writer)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, WriteFailed)
+	defer cancel()
+
+	assertT.Nil(em.Put("Hi", 2))
+	assertT.True(em.Replace("Hi", 3))
+	assertT.True(em.Remove("Hi"))
+	assertT.False(em.Remove("Hello"))
+	ops, data := writer.snapshot()
+	assertT.Equal([]string{"W:Hi", "W:Hi", "D:Hi", "D:Hello"}, ops)
+	assertT.Empty(data)
+	assertT.Equal(0, em.PendingWrites())
+
+	writer.setFailures(1)
+	assertT.NotNil(em.Put("Hi", 2))
+	assertT.False(em.ContainsKey("Hi"))
+	ev := <-events
+	assertT.Equal(WriteFailed, ev.Type)
+	assertT.Equal("Hi", ev.Key)
+	assertT.NotNil(ev.Err)
+
+	assertT.Nil(em.Put("Hi", 2))
+	writer.setFailures(1)
+	assertT.False(em.Replace("Hi", 3))
+	v, _ := em.Peek("Hi")
+	assertT.Equal(2, v)
+	writer.setFailures(1)
+	assertT.False(em.Remove("Hi"))
+	assertT.True(em.ContainsKey("Hi"))
+}
+
+func TestWriteBehind(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteBehind(writer, time.Hour, 0, 0)
+
+	assertT.Nil(em.Put("Hi", 2))
+	assertT.Nil(em.Put("Hello", 5))
+	assertT.True(em.Replace("Hi", 3))
+	assertT.True(em.Remove("Hello"))
+	assertT.Equal(2, em.PendingWrites())
+	ops, _ := writer.snapshot()
+	assertT.Empty(ops)
+
+	em.Flush()
+	ops, data := writer.snapshot()
+	assertT.Equal([]string{"W:Hi", "D:Hello"}, ops)
+	assertT.Equal(map[string]int{"Hi": 3}, data)
+	assertT.Equal(0, em.PendingWrites())
+
+	assertT.Nil(em.Put("World!", 6))
+	em.Discard()
+	_, data = writer.snapshot()
+	assertT.Equal(map[string]int{"Hi": 3, "World!": 6}, data)
+}
+
+func TestWriteBehindBatches(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteBehind(writer, time.Hour, 2, 0)
+	defer em.Discard()
+
+	assertT.Nil(em.Put("A", 1))
+	time.Sleep(sleepTime)
+	ops, _ := writer.snapshot()
+	assertT.Empty(ops)
+
+	assertT.Nil(em.Put("B", 2))
+	assertT.Eventually(func() bool { return em.PendingWrites() == 0 }, waitTime, sleepTime)
+	ops, _ = writer.snapshot()
+	assertT.Equal([]string{"W:A", "W:B"}, ops)
+}
+
+func TestWriteBehindRetries(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteBehind(writer, sleepTime, 0, 2)
+	defer em.Discard()
+	events, cancel := em.Subscribe(10, WriteFailed)
+	defer cancel()
+
+	writer.setFailures(2)
+	assertT.Nil(em.Put("Hi", 2))
+	assertT.Eventually(func() bool {
+		_, data := writer.snapshot()
+		return data["Hi"] == 2
+	}, waitTime, sleepTime)
+
+	writer.setFailures(3)
+	assertT.Nil(em.Put("Hello", 5))
+	select {
+	case ev := <-events:
+		assertT.Equal("Hello", ev.Key)
+		assertT.Equal(5, ev.Value)
+	case <-time.After(waitTime):
+		t.Fatal("Write failure was not reported")
+	}
+	assertT.Equal(0, em.PendingWrites())
+}
+
+func TestWriteBehindSuperseded(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteBehind(writer, time.Hour, 0, 1)
+	defer em.Discard()
+
+	assertT.Nil(em.Put("Hi", 2))
+	writer.setFailures(1)
+	em.Flush()
+	assertT.Equal(1, em.PendingWrites())
+
+	assertT.Nil(em.Put("Hi", 3))
+	em.Flush()
+	_, data := writer.snapshot()
+	assertT.Equal(map[string]int{"Hi": 3}, data)
+}
+
+func TestWriteBehindCoalescing(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	wb := newWriteBehind[string, int](writer, time.Hour, 0, 0, func(key string, val int, err error) {})
+	wb.enqueue("A", 0, false)
+	wb.enqueue("B", 0, false)
+	for i := 1; i <= 1000; i++ {
+		wb.enqueue("A", i, false)
+	}
+	assertT.Equal(2, wb.size())
+	assertT.LessOrEqual(len(wb.order), 2*2+16+1) // stale positions are dropped
+
+	wb.stop()
+	ops, data := writer.snapshot()
+	assertT.Equal([]string{"W:B", "W:A"}, ops)
+	assertT.Equal(1000, data["A"])
+	assertT.Empty(wb.order)
+}
+
+func TestWriteBehindFlushWhileClosing(t *testing.T) {
+	assertT := assert.New(t)
+
+	writer := newRecordingWriter()
+	em := NewExpiryMap[string, int]().
+		WithWriteBehind(writer, time.Hour, 0, 0)
+	assertT.Nil(em.Put("Hi", 2))
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-closed:
+				return
+			default:
+				em.Flush()
+				_ = em.PendingWrites()
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	em.Discard()
+	close(closed)
+	<-done
+
+	assertT.Equal(0, em.PendingWrites())
+	_, data := writer.snapshot()
+	assertT.Equal(map[string]int{"Hi": 2}, data)
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// Creates a hash function for keys of comparable type. Strings, integers and floats are hashed directly,
+// other types - field by field, so that keys equal by `==` get equal hashes. Hash values are stable only within the process.
+func NewHasher[K comparable]() func(K) uint64 {
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		switch k := any(key).(type) {
+		case string:
+			return maphash.String(seed, k)
+		case int:
+			return mix(uint64(k))
+		case int8:
+			return mix(uint64(k))
+		case int16:
+			return mix(uint64(k))
+		case int32:
+			return mix(uint64(k))
+		case int64:
+			return mix(uint64(k))
+		case uint:
+			return mix(uint64(k))
+		case uint8:
+			return mix(uint64(k))
+		case uint16:
+			return mix(uint64(k))
+		case uint32:
+			return mix(uint64(k))
+		case uint64:
+			return mix(k)
+		case uintptr:
+			return mix(uint64(k))
+		case float32:
+			return mix(floatBits(float64(k)))
+		case float64:
+			return mix(floatBits(k))
+		default:
+			var h maphash.Hash
+			h.SetSeed(seed)
+			hashValue(&h, reflect.ValueOf(key))
+			return h.Sum64()
+		}
+	}
+}
+
+// Bits of the float with both zeros mapped to the same value
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
+
+func writeUint(h *maphash.Hash, x uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	_, _ = h.Write(buf[:])
+}
+
+// Feeds the parts of the value that take part in `==` comparison to the hash
+func hashValue(h *maphash.Hash, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		writeUint(h, 0)
+	case reflect.Bool:
+		if v.Bool() {
+			writeUint(h, 1)
+		} else {
+			writeUint(h, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint(h, floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		writeUint(h, floatBits(real(c)))
+		writeUint(h, floatBits(imag(c)))
+	case reflect.String:
+		_, _ = h.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		writeUint(h, uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			writeUint(h, 0)
+		} else {
+			hashValue(h, v.Elem())
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" {
+				hashValue(h, v.Field(i))
+			}
+		}
+	}
+}
+
+// Finalizer of SplitMix64 - spreads integer bits over the whole word
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
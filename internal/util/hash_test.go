@@ -0,0 +1,70 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct{ x, y int }
+
+type sample struct {
+	name  string
+	value float64
+	next  *sample
+	tags  [2]any
+}
+
+func TestHasher(t *testing.T) {
+	assertT := assert.New(t)
+
+	hashS := NewHasher[string]()
+	assertT.Equal(hashS("Hello"), hashS("Hello"))
+	assertT.NotEqual(hashS("Hello"), hashS("World"))
+
+	hashI := NewHasher[int]()
+	assertT.Equal(hashI(42), hashI(42))
+	assertT.NotEqual(hashI(1), hashI(2))
+
+	hashP := NewHasher[point]()
+	assertT.Equal(hashP(point{1, 2}), hashP(point{1, 2}))
+	assertT.NotEqual(hashP(point{1, 2}), hashP(point{2, 1}))
+}
+
+func TestHasherEqualKeys(t *testing.T) {
+	assertT := assert.New(t)
+
+	negZero := math.Copysign(0, -1)
+	hashF := NewHasher[float64]()
+	assertT.Equal(hashF(0), hashF(negZero))
+	assertT.NotEqual(hashF(1), hashF(2))
+
+	hashI8 := NewHasher[int8]()
+	assertT.NotEqual(hashI8(1), hashI8(2))
+
+	node := &sample{}
+	hashS := NewHasher[sample]()
+	a := sample{"a", 0, node, [2]any{1, "x"}}
+	b := sample{"a", negZero, node, [2]any{1, "x"}}
+	assertT.Equal(a, b)
+	assertT.Equal(hashS(a), hashS(b))
+	assertT.NotEqual(hashS(a), hashS(sample{"a", 1, node, [2]any{1, "x"}}))
+	assertT.NotEqual(hashS(a), hashS(sample{"a", 0, &sample{}, [2]any{1, "x"}}))
+	assertT.NotEqual(hashS(a), hashS(sample{"a", 0, node, [2]any{2, "x"}}))
+
+	hashA := NewHasher[any]()
+	assertT.Equal(hashA(nil), hashA(nil))
+	assertT.Equal(hashA(0.0), hashA(negZero))
+}
+
+func TestHasherSpread(t *testing.T) {
+	hash := NewHasher[int]()
+	buckets := make([]int, 8)
+	for i := 0; i < 8000; i++ {
+		buckets[hash(i)%8]++
+	}
+	for _, n := range buckets {
+		assert.InDelta(t, 1000, n, 150)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !race
+
+package util
+
+import "unsafe"
+
+func raceAcquire(addr unsafe.Pointer) {}
+
+func raceRelease(addr unsafe.Pointer) {}
+
+func raceReleaseMerge(addr unsafe.Pointer) {}
@@ -0,0 +1,23 @@
+//go:build race
+
+package util
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// Semaphore hand-offs are invisible to the race detector, hence the lock reports its happens-before edges explicitly,
+// the same way `sync.RWMutex` does.
+
+func raceAcquire(addr unsafe.Pointer) {
+	runtime.RaceAcquire(addr)
+}
+
+func raceRelease(addr unsafe.Pointer) {
+	runtime.RaceRelease(addr)
+}
+
+func raceReleaseMerge(addr unsafe.Pointer) {
+	runtime.RaceReleaseMerge(addr)
+}
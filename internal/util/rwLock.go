@@ -3,7 +3,7 @@ package util
 import (
 	"sync"
 	"sync/atomic"
-	_ "unsafe"
+	"unsafe"
 )
 
 // "Upgradable" R/W lock "inspired" by https://upstash.com/blog/upgradable-rwlock-for-go
@@ -66,6 +66,7 @@ func (rw *UpgradableRWMutex) UpgradeWLock() {
 	if r != 0 && rw.readerWait.Add(r) != 0 {
 		semaphoreAcquire(&rw.writerSem)
 	}
+	rw.acquiredWrite()
 }
 
 // Undoes upgrade of r-locks and unlocksthe R-lock
@@ -79,7 +80,13 @@ func (rw *UpgradableRWMutex) UpgradableRUnlock() {
 	}
 }
 
-// -- Standard functionality of `sync.RWMutex` (no racing checks though) --
+// -- Standard functionality of `sync.RWMutex` --
+
+// Reports to the race detector that writing follows preceding reads and writes
+func (rw *UpgradableRWMutex) acquiredWrite() {
+	raceAcquire(unsafe.Pointer(&rw.readerSem))
+	raceAcquire(unsafe.Pointer(&rw.writerSem))
+}
 
 // Locks rw for writing - standard implementation.
 func (rw *UpgradableRWMutex) lock() {
@@ -91,10 +98,12 @@ func (rw *UpgradableRWMutex) lock() {
 	if r != 0 && rw.readerWait.Add(r) != 0 {
 		semaphoreAcquire(&rw.writerSem)
 	}
+	rw.acquiredWrite()
 }
 
 // Unlocks the lock for writing - standard implementation.
 func (rw *UpgradableRWMutex) unlock() {
+	raceRelease(unsafe.Pointer(&rw.readerSem))
 	// Announce to readers there is no active writer.
 	r := rw.readerCount.Add(rwmutexMaxReaders)
 	// Unblock blocked readers, if any.
@@ -111,10 +120,12 @@ func (rw *UpgradableRWMutex) rLock() {
 		// A writer is pending, wait for it.
 		semaphoreAcquire(&rw.readerSem)
 	}
+	raceAcquire(unsafe.Pointer(&rw.readerSem))
 }
 
 // Undoes a single rLock call - standard implementation
 func (rw *UpgradableRWMutex) rUnlock() {
+	raceReleaseMerge(unsafe.Pointer(&rw.writerSem))
 	if r := rw.readerCount.Add(-1); r < 0 {
 		// Outlined slow-path to allow the fast-path to be inlined
 		// A writer is pending.